@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Detail string    `json:"detail"`
+}
+
+var auditMu sync.Mutex
+
+// audit appends an entry to audit.log in the data directory, one JSON
+// object per line, and echoes it to the log.
+func audit(event string, detail string) {
+	fmt.Fprintln(os.Stderr, "Audit: "+event+": "+detail)
+	line, err := json.Marshal(AuditEntry{Time: time.Now().UTC(), Event: event, Detail: detail})
+	if err != nil {
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(settings.dataDir(), "audit.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot write the audit log: "+err.Error())
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot write the audit log: "+err.Error())
+	}
+}
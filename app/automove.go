@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,163 +9,441 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+// Modes of an automove: move copies the thread and deletes the original,
+// copy keeps the original, and link only posts a summary with a link to the
+// thread.
+const (
+	modeMove = "move"
+	modeCopy = "copy"
+	modeLink = "link"
 )
 
 type Automove struct {
-	Trigger string `json:"trigger"`
-	From    string `json:"from_channel"`
-	To      string `json:"to_channel"`
-	User    User   `json:"-"`
+	Trigger string     `json:"trigger"`
+	From    string     `json:"from_channel"`
+	To      string     `json:"to_channel"`
+	Mode    string     `json:"mode,omitempty"`
+	User    slack.User `json:"-"`
+	Note    string     `json:"-"`
+	Skip    string     `json:"-"`
+	restore bool
 }
 
-func (a Automove) Do(message_id string) error {
-
-	var slack SlackRequest
-
-	slack.user = User{Id: a.User.Id, TeamId: a.User.TeamId}
-	slack.data = make(map[string]string)
-	slack.data["channel"] = a.From
-	slack.data["ts"] = message_id
-	slack.data["limit"] = "30"
+// mode returns the mode of the automove. Rules without one copy when
+// no_remove is set and move otherwise.
+func (a Automove) mode() string {
+	switch a.Mode {
+	case modeMove, modeCopy, modeLink:
+		return a.Mode
+	}
+	if settings.NoRemove {
+		return modeCopy
+	}
+	return modeMove
+}
 
-	thread, err := slack.GetThread()
+// Do copies the thread to the destination and, in the move mode, deletes
+// the original. The record is returned once the copy is complete, even when
+// deleting failed. A copy that fails half way is rolled back, and the
+// original is only deleted after the copy is complete. A move interrupted by
+// shutdown or a crash continues where it stopped. In the link mode only the
+// summary is posted.
+func (a Automove) Do(ctx context.Context, message_id string) (*MoveRecord, error) {
+	if a.mode() == modeLink {
+		return a.link(ctx, message_id)
+	}
+	thread, err := a.openThread(ctx, message_id)
 	if err != nil {
-		return errors.New("Cannot retrieve thread: " + err.Error())
+		return nil, fmt.Errorf("Cannot retrieve thread: %w", err)
 	}
-	if thread[0].Ts != thread[0].ThreadTs && thread[0].ThreadTs != "" {
-		return nil
+	var copied []slack.Message
+	tx, err := openTransaction(a.From, a.To, message_id)
+	if err != nil {
+		return nil, err
 	}
-
-	slack.data = make(map[string]string)
-	slack.data["channel"] = a.To
-	var ts string
-	for i := 0; i < len(thread); i++ {
-		slack.data["user"] = thread[i].User
-		timestamp := strings.Split(thread[i].Ts, ".")
-		unixTime, _ := strconv.ParseInt(timestamp[0], 10, 64)
-		t := time.Unix(unixTime, 0)
-
-		u, err := slack.GetUser()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Cannot get user: "+err.Error())
+	err = tx.discardPartial(ctx)
+	if err != nil {
+		return nil, tx.rollback(ctx, err)
+	}
+	ts := tx.DestTs
+	record := &MoveRecord{From: a.From, To: a.To, Ts: message_id, User: a.User.Id, Copies: tx.Copies, Files: tx.Files, Mode: a.mode(), Kept: a.mode() != modeMove}
+	for thread.Next(ctx) {
+		m := thread.Message()
+		if len(copied) == 0 && m.Ts != m.ThreadTs && m.ThreadTs != "" {
+			return nil, nil
 		}
-		thread[i].Blocks = []Block{}
-
-		if u.RealName != "" {
-			slack.data["username"] = u.RealName
-			slack.data["icon_url"] = u.Profile.Image72
-		} else {
-			delete(slack.data, "username")
-			delete(slack.data, "icon_url")
-			thread[i].Blocks = append(thread[i].Blocks, Block{Type: "context", Elements: []Element{{Type: "mrkdwn", Text: "Posted by <@" + thread[i].User + ">"}}})
-		}
-		if len(thread[i].Text) > 0 {
-			thread[i].Blocks = append(thread[i].Blocks, Block{Type: "section", Text: &Element{Type: "mrkdwn", Text: thread[i].Text}})
-			slack.data["text"] += ">" + strings.ReplaceAll(thread[i].Text, "\n", "\n>")
-			slack.data["text"] += "\non " + t.Format("Monday, January 2, 2006 at 15:04")
-		}
-
-		if len(thread[i].Text) > 0 || len(thread[i].Files) > 0 {
-			var filestring string
-			if len(thread[i].Files) > 0 {
-				filestring += "Uploaded file"
-				if len(thread[i].Files) > 1 {
-					filestring += "s"
-				}
-				filestring += "\n"
-			}
-			thread[i].Blocks = append(thread[i].Blocks, Block{Type: "context", Elements: []Element{{Type: "plain_text", Text: filestring + "on " + t.Format("Monday, January 2, 2006 at 15:04")}}})
+		if m.Ts == a.Skip {
+			// The mention that asked for the move is not copied, but it is
+			// deleted with the thread.
+			copied = append(copied, slack.Message{Ts: m.Ts, Text: m.Text, BotId: m.BotId})
+			continue
 		}
-		if thread[i].Attachments != nil {
-			for _, ant := range thread[i].Attachments {
-				if len(ant.Files) > 0 {
-					thread[i].Files = append(thread[i].Files, ant.Files...)
-				}
-				if len(ant.MessageBlocks) > 0 {
-					if at, err := json.Marshal(thread[i].Attachments); err == nil {
-						slack.data["attachments"] = string(at)
-					} else {
-						return errors.New("Error on marshalings attached object: " + err.Error())
-					}
-				}
+		if _, ok := tx.Copies[m.Ts]; ok {
+			// Copied before the move was interrupted.
+			copied = append(copied, slack.Message{Ts: m.Ts, Text: m.Text, BotId: m.BotId})
+			continue
+		}
+		msg, files := a.compose(ctx, m)
+		if len(files) > 0 {
+			filelist, err := slack.UploadFiles(ctx, api, files)
+			if err != nil {
+				return nil, tx.rollback(ctx, err)
+			}
+			if ts != "" && m.Ts != m.ThreadTs {
+				msg.ThreadTs = ts
 			}
+			m_ts, err := a.post(ctx, msg)
+			if err != nil {
+				return nil, tx.rollback(ctx, fmt.Errorf("Cannot post the first message: %w", err))
+			}
+			tx.add(m.Ts, m_ts)
+			if ts == "" {
+				ts = m_ts
+			}
+			uploaded, err := api.CompleteUpload(ctx, a.To, "Attached files:", ts, filelist)
+			if err != nil {
+				return nil, tx.rollback(ctx, fmt.Errorf("Cannot complete upload: %w", err))
+			}
+			files_ts, err := a.sharedTs(ctx, ts, m_ts, uploaded)
+			if err != nil {
+				return nil, tx.rollback(ctx, err)
+			}
+			tx.add(m.Ts, files_ts)
+			tx.copied(m.Ts, m_ts, files_ts)
+			copied = append(copied, slack.Message{Ts: m.Ts, Text: m.Text, BotId: m.BotId})
+			continue
+		}
+		var m_ts string
+		if ts != "" {
+			msg.ThreadTs = ts
+			m_ts, err = a.post(ctx, msg)
 		} else {
-			delete(slack.data, "attachments")
+			ts, err = a.post(ctx, msg)
+			m_ts = ts
 		}
-		if len(thread[i].Reactions) > 0 {
-			var elems []Element
-			for _, r := range thread[i].Reactions {
-				elems = append(elems, Element{Type: "mrkdwn", Text: ":" + r.Name + ":  *" + strconv.Itoa(r.Count) + "*"})
+		if err != nil {
+			if blocks, jsonErr := json.Marshal(msg.Blocks); jsonErr == nil {
+				fmt.Fprintln(os.Stderr, "Blocks: "+string(blocks))
 			}
-			thread[i].Blocks = append(thread[i].Blocks, Block{Type: "context", Elements: elems})
+			return nil, tx.rollback(ctx, fmt.Errorf("Cannot post: %w", err))
 		}
-
-		if blocks, err := json.Marshal(thread[i].Blocks); err == nil && len(thread[i].Blocks) > 0 {
-			slack.data["blocks"] = string(blocks)
-		} else {
-			fmt.Fprintln(os.Stderr, "Blocks list is empty or JSON error on marshal message block")
-		}
-		if len(thread[i].Files) > 0 {
-			var filelist []map[string]string
-			for _, file := range thread[i].Files {
-				url, file_id, err := slack.GetUploadUrl(file.Name, file.Size)
-				if err != nil {
-					return errors.New("Cannot get upload url for " + file.Name + "(" + strconv.Itoa(file.Size) + "): " + err.Error())
-				}
-				filelist = append(filelist, map[string]string{"id": file_id, "title": file.Title})
-				err = ReloadFile(file.UrlPrivate, url, file.MimeType)
-				if err != nil {
-					return errors.New("Cannot reload file: " + err.Error())
-				}
+		tx.add(m.Ts, m_ts)
+		tx.copied(m.Ts, m_ts, "")
+		copied = append(copied, slack.Message{Ts: m.Ts, Text: m.Text, BotId: m.BotId})
+	}
+	if err := thread.Err(); err != nil {
+		return nil, tx.rollback(ctx, fmt.Errorf("Cannot retrieve thread: %w", err))
+	}
+	record.DestTs = ts
+	record.Tombstone = tx.Tombstone
+	// The copy is complete, from here on the move is recorded instead. A
+	// shutdown during the deletions keeps the journal, so they go on after
+	// the restart.
+	interrupted := false
+	defer func() {
+		if !interrupted {
+			tx.commit()
+		}
+	}()
+	if !record.Kept {
+		var undeletable error
+		skipped := 0
+		tombstone := a.tombstone(ctx, record)
+		for _, message := range copied {
+			if message.Ts == record.Ts && record.Tombstone == record.Ts {
+				// The root became the tombstone before the move was interrupted.
+				continue
 			}
-			if ts != "" && thread[i].Ts != thread[i].ThreadTs {
-				slack.data["thread_ts"] = ts
+			if message.Ts == record.Ts && tombstone != nil && a.replaceRoot(ctx, record, tombstone) {
+				tx.tombstoned(record.Tombstone)
+				continue
+			}
+			err := a.remove(ctx, message)
+			if slack.IsError(err, "message_not_found") {
+				// Deleted before the move was interrupted.
+				err = nil
+			}
+			if slack.IsError(err, "cant_delete_message") {
+				undeletable = err
+				skipped++
+				continue
+			}
+			if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+				interrupted = true
+				return nil, fmt.Errorf("Deleting the originals was interrupted: %w", err)
 			}
-			m_ts, err := slack.PostMessage(false)
 			if err != nil {
-				return errors.New("Cannot post the first message: " + err.Error())
+				return record, fmt.Errorf("Cannot delete: %s %w", message.Text, err)
 			}
-			if ts == "" {
-				ts = m_ts
+			if message.Ts == record.Ts && tombstone != nil && record.Tombstone == "" {
+				a.postTombstone(ctx, record, tombstone)
+				tx.tombstoned(record.Tombstone)
 			}
-			err = slack.CompleteUpload(a.To, "Attached files:", ts, filelist)
+		}
+		if undeletable != nil {
+			return record, fmt.Errorf("The thread was copied, but %d of %d messages could not be deleted: %w", skipped, len(copied), undeletable)
+		}
+	}
+	return record, nil
+}
+
+// sharedTs finds the message the uploaded files were shared in, a reply
+// after the given ts. Slack shares files in the background, so when the
+// upload reply does not name the message yet, the thread is polled for it.
+func (a Automove) sharedTs(ctx context.Context, thread_ts string, after string, uploaded []slack.File) (string, error) {
+	ids := make(map[string]bool)
+	for _, f := range uploaded {
+		if ts := f.SharedTs(a.To); ts != "" {
+			return ts, nil
+		}
+		ids[f.Id] = true
+	}
+	for {
+		params := slack.RepliesRequest{Channel: a.To, Ts: thread_ts, Oldest: after, Limit: 100}
+		for {
+			response, err := api.Replies(ctx, params)
 			if err != nil {
-				return errors.New("Cannot complete upload: " + err.Error())
+				return "", fmt.Errorf("Cannot find the shared files: %w", err)
 			}
-			for {
-				msgs, err := slack.GetThreadLimit(1, a.To, ts)
-				if err != nil {
-					return errors.New("Cannot retrieve the last message from thread: " + err.Error())
+			for _, m := range response.Messages {
+				if m.Ts == thread_ts {
+					continue
 				}
-				if len(msgs) == 2 && msgs[1].Ts != m_ts {
-					break
+				for _, f := range m.Files {
+					if ids[f.Id] {
+						return m.Ts, nil
+					}
 				}
-				time.Sleep(250 * time.Millisecond)
 			}
+			params.Cursor = response.Metadata.NextCursor
+			if params.Cursor == "" {
+				break
+			}
+		}
+		select {
+		case <-time.After(250 * time.Millisecond):
+		case <-ctx.Done():
+			return "", errors.New("Upload was not completed: " + ctx.Err().Error())
+		}
+	}
+}
+
+// link posts a summary of the thread with a link to it in the destination.
+// The record maps the first message to the summary.
+func (a Automove) link(ctx context.Context, message_id string) (*MoveRecord, error) {
+	thread, err := a.openThread(ctx, message_id)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot retrieve thread: %w", err)
+	}
+	var first slack.Message
+	replies := 0
+	for thread.Next(ctx) {
+		m := thread.Message()
+		if first.Ts == "" {
+			if m.Ts != m.ThreadTs && m.ThreadTs != "" {
+				return nil, nil
+			}
+			first = m
 			continue
 		}
-		if ts != "" {
-			slack.data["thread_ts"] = ts
-			_, err = slack.PostMessage(false)
-		} else {
-			ts, err = slack.PostMessage(false)
+		replies++
+	}
+	if err := thread.Err(); err != nil {
+		return nil, fmt.Errorf("Cannot retrieve thread: %w", err)
+	}
+	if first.Ts == "" {
+		return nil, nil
+	}
+	permalink, err := api.GetPermalink(ctx, a.From, first.Ts)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get the link to the thread: %w", err)
+	}
+	summary := "<" + permalink + "|Thread> by <@" + first.User + "> in <#" + a.From + ">"
+	switch replies {
+	case 0:
+	case 1:
+		summary += ", 1 reply"
+	default:
+		summary += ", " + strconv.Itoa(replies) + " replies"
+	}
+	msg := slack.PostMessageRequest{Channel: a.To, Text: summary}
+	if first.Text != "" {
+		msg.Text += "\n>" + strings.ReplaceAll(truncate(first.Text, maxSummaryText), "\n", "\n>")
+	}
+	msg.Blocks = append(sectionBlocks(msg.Text), slack.Block{Type: "context", Elements: []slack.Element{{Type: "mrkdwn", Text: "Linked by <@" + a.User.Id + ">"}}})
+	ts, err := a.post(ctx, msg)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot post the link: %w", err)
+	}
+	return &MoveRecord{From: a.From, To: a.To, Ts: message_id, DestTs: ts, User: a.User.Id, Copies: map[string]string{first.Ts: ts}, Mode: modeLink, Kept: true}, nil
+}
+
+// remove deletes a moved message from the source. Copies the bot posted are
+// deleted with the bot token when a move is undone.
+func (a Automove) remove(ctx context.Context, m slack.Message) error {
+	if a.restore && m.BotId != "" {
+		return api.DeleteBotMessage(ctx, a.From, m.Ts)
+	}
+	return api.DeleteMessage(ctx, a.From, m.Ts)
+}
 
+// compose builds the copy of a message for the destination. The files of
+// the message are returned separately, they are uploaded anew. When a move
+// is undone, the copies are posted back as they are.
+func (a Automove) compose(ctx context.Context, m slack.Message) (slack.PostMessageRequest, []slack.File) {
+	msg := slack.PostMessageRequest{Channel: a.To}
+	if a.restore && m.BotId != "" {
+		msg.Text, msg.Blocks, msg.Attachments, msg.Username = m.Text, m.Blocks, m.Attachments, m.Username
+		if m.Icons != nil {
+			msg.IconUrl = m.Icons.Image72
+			if msg.IconUrl == "" {
+				msg.IconUrl = m.Icons.Image48
+			}
 		}
+		return msg, m.Files
+	}
+	timestamp := strings.Split(m.Ts, ".")
+	unixTime, _ := strconv.ParseInt(timestamp[0], 10, 64)
+	t := time.Unix(unixTime, 0)
+
+	u, err := api.GetUser(ctx, m.User)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot get user: "+err.Error())
+	}
+	m.Blocks = []slack.Block{}
+
+	if u.RealName != "" {
+		msg.Username = u.RealName
+		msg.IconUrl = u.Profile.Image72
+	} else {
+		m.Blocks = append(m.Blocks, slack.Block{Type: "context", Elements: []slack.Element{{Type: "mrkdwn", Text: "Posted by <@" + m.User + ">"}}})
+	}
+	if len(m.Text) > 0 {
+		m.Blocks = append(m.Blocks, sectionBlocks(m.Text)...)
+		msg.Text = ">" + strings.ReplaceAll(m.Text, "\n", "\n>")
+		msg.Text += "\non " + t.Format("Monday, January 2, 2006 at 15:04")
+	}
+
+	if len(m.Text) > 0 || len(m.Files) > 0 {
+		var filestring string
+		if len(m.Files) > 0 {
+			filestring += "Uploaded file"
+			if len(m.Files) > 1 {
+				filestring += "s"
+			}
+			filestring += "\n"
+		}
+		m.Blocks = append(m.Blocks, slack.Block{Type: "context", Elements: []slack.Element{{Type: "plain_text", Text: filestring + "on " + t.Format("Monday, January 2, 2006 at 15:04")}}})
+	}
+	for _, ant := range m.Attachments {
+		if len(ant.Files) > 0 {
+			m.Files = append(m.Files, ant.Files...)
+		}
+		if len(ant.MessageBlocks) > 0 {
+			msg.Attachments = m.Attachments
+		}
+	}
+	if len(m.Reactions) > 0 {
+		var elems []slack.Element
+		for _, r := range m.Reactions {
+			elems = append(elems, slack.Element{Type: "mrkdwn", Text: ":" + r.Name + ":  *" + strconv.Itoa(r.Count) + "*"})
+		}
+		m.Blocks = append(m.Blocks, slack.Block{Type: "context", Elements: elems})
+	}
+
+	if len(m.Blocks) > 0 {
+		msg.Blocks = m.Blocks
+	} else {
+		fmt.Fprintln(os.Stderr, "Blocks list is empty")
+	}
+	return msg, m.Files
+}
+
+// openThread fetches the first page of the thread, joining the source
+// channel when the bot is not a member of it yet.
+func (a Automove) openThread(ctx context.Context, ts string) (*slack.ThreadIterator, error) {
+	thread := slack.NewThreadIterator(api, a.From, ts, 100)
+	err := thread.Open(ctx)
+	if slack.IsError(err, "not_in_channel") {
+		err = a.join(ctx, a.From, err)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Blocks: "+slack.data["blocks"])
-			return errors.New("cannot post: " + err.Error())
+			return nil, err
 		}
+		thread = slack.NewThreadIterator(api, a.From, ts, 100)
+		err = thread.Open(ctx)
 	}
-	if !settings.NoRemove {
-		for _, message := range thread {
-			slack.data = make(map[string]string)
-			slack.data["channel"] = a.From
-			slack.data["ts"] = message.Ts
-			err = slack.DeleteMessage()
-			if err != nil {
-				return errors.New("Cannot delete: " + message.Text + " " + err.Error())
+	if err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
+
+// post sends a message to the destination. The bot joins a public
+// destination it is not a member of, and an overlong fallback text is cut,
+// since the blocks carry the full message anyway.
+func (a Automove) post(ctx context.Context, msg slack.PostMessageRequest) (string, error) {
+	ts, err := api.PostMessage(ctx, msg, false)
+	if slack.IsError(err, "not_in_channel", "channel_not_found") {
+		err = a.join(ctx, msg.Channel, err)
+		if err != nil {
+			return "", err
+		}
+		ts, err = api.PostMessage(ctx, msg, false)
+	}
+	if slack.IsError(err, "msg_too_long") && len(msg.Blocks) > 0 && len(msg.Text) > maxFallbackText {
+		msg.Text = truncate(msg.Text, maxFallbackText)
+		ts, err = api.PostMessage(ctx, msg, false)
+	}
+	return ts, err
+}
+
+// join makes the bot a member of a public channel. When that is not
+// possible, the original error is kept unless joining failed for a reason
+// an admin has to fix, such as a missing scope.
+func (a Automove) join(ctx context.Context, channel string, cause error) error {
+	fmt.Fprintln(os.Stderr, "Bot is not in channel "+channel+", joining")
+	err := api.JoinChannel(ctx, channel)
+	if err == nil {
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "Cannot join channel "+channel+": "+err.Error())
+	if slack.IsError(err, "missing_scope", "is_archived", "invalid_auth", "not_authed", "token_revoked") {
+		return err
+	}
+	return cause
+}
+
+const maxFallbackText = 3000
+const maxSectionText = 3000
+const maxSummaryText = 300
+
+// sectionBlocks splits text into section blocks within Slack's limit on
+// the text of a single block.
+func sectionBlocks(text string) []slack.Block {
+	var blocks []slack.Block
+	runes := []rune(text)
+	for len(runes) > maxSectionText {
+		cut := maxSectionText
+		for i := cut - 1; i > maxSectionText/2; i-- {
+			if runes[i] == '\n' {
+				cut = i + 1
+				break
 			}
 		}
+		blocks = append(blocks, slack.Block{Type: "section", Text: &slack.Element{Type: "mrkdwn", Text: string(runes[:cut])}})
+		runes = runes[cut:]
+	}
+	return append(blocks, slack.Block{Type: "section", Text: &slack.Element{Type: "mrkdwn", Text: string(runes)}})
+}
+
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
 	}
-	return nil
+	return string(runes[:limit-1]) + "…"
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+type ChannelState struct {
+	Name     string `json:"name,omitempty"`
+	Archived bool   `json:"archived,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+// ChannelStore keeps what the bot learned from channel events about the
+// channels of the automove rules, in channels.json.
+type ChannelStore struct {
+	mu       sync.Mutex
+	path     string
+	Channels map[string]ChannelState `json:"channels"`
+}
+
+var channels *ChannelStore
+
+func loadChannelStore(path string) (*ChannelStore, error) {
+	s := &ChannelStore{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(data, s)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse %s: %w", path, err)
+		}
+	}
+	if s.Channels == nil {
+		s.Channels = make(map[string]ChannelState)
+	}
+	return s, nil
+}
+
+func (s *ChannelStore) Get(channel string) ChannelState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Channels[channel]
+}
+
+func (s *ChannelStore) Update(channel string, update func(state *ChannelState)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.Channels[channel]
+	update(&state)
+	s.Channels[channel] = state
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path, data, 0600)
+}
+
+// unavailable tells why a channel cannot take part in a move, or "".
+func (s ChannelState) unavailable() string {
+	switch {
+	case s.Deleted:
+		return "deleted"
+	case s.Archived:
+		return "archived"
+	}
+	return ""
+}
+
+// handleChannelEvent follows archive, unarchive, deletion and renames of
+// channels. Rules with an archived or deleted channel are paused until it
+// is unarchived, and the source channel of every affected rule is told.
+func handleChannelEvent(ctx context.Context, event slack.Event) {
+	var notice string
+	err := channels.Update(event.Channel, func(state *ChannelState) {
+		switch event.Type {
+		case "channel_archive", "group_archive":
+			state.Archived = true
+			notice = "is archived, the automove is paused"
+		case "channel_unarchive", "group_unarchive":
+			state.Archived = false
+			notice = "is unarchived, the automove is active again"
+		case "channel_deleted", "group_deleted":
+			state.Deleted = true
+			notice = "was deleted, the automove is paused. Change the rule in config.json"
+		case "channel_rename", "group_rename":
+			state.Name = event.ChannelName
+			notice = "was renamed to #" + event.ChannelName
+		}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot save the channel state: "+err.Error())
+	}
+	for _, move := range settings.Automoves {
+		if move.From != event.Channel && move.To != event.Channel {
+			continue
+		}
+		rule := "from <#" + move.From + "> to <#" + move.To + "> on :" + move.Trigger + ":"
+		audit(event.Type, "channel "+event.Channel+" of the automove "+rule)
+		text := "The channel <#" + event.Channel + "> of the automove " + rule + " " + notice + "."
+		_, err := api.PostMessage(ctx, slack.PostMessageRequest{Channel: move.From, Text: text}, false)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot post the notice to "+move.From+": "+err.Error())
+			tellAdmins(ctx, text)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
@@ -11,11 +12,16 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/aageorg/slackbot_prod/slack"
 )
 
 var slackSignSecret string
@@ -27,6 +33,11 @@ var privkeyFile string
 
 var settings Database
 var voting Voting
+var events Deduplicator
+var api slack.API
+
+var appCtx = context.Background()
+var moves sync.WaitGroup
 
 type Voting struct {
 	votes map[string]int
@@ -73,8 +84,6 @@ func (v *Voting) Cancel(message_ts string) {
 	delete(v.votes, message_ts)
 }
 
-const slackAPIUrl = "https://slack.com/api/"
-
 var state string
 
 func GetHash(data []byte) string {
@@ -114,26 +123,44 @@ func OAuth(res http.ResponseWriter, req *http.Request) {
 	if len(code) == 0 {
 		return
 	}
-	var slack SlackRequest
-	slack.data = make(map[string]string)
-	slack.data["code"] = code
-	slack.data["client_id"] = slackClientID
-	slack.data["client_secret"] = slackClientSecret
-	authedUsers, err := slack.OauthV2Access()
+	if !validState(req) {
+		fmt.Fprintln(os.Stderr, "OAuth callback with an unknown state")
+		res.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(res, "The installation has expired or was not started here. Start it again at /setup.")
+		return
+	}
+	authedUsers, err := api.OauthV2Access(req.Context(), slack.OAuthAccessRequest{
+		Code:         code,
+		ClientId:     slackClientID,
+		ClientSecret: slackClientSecret,
+	})
 	if err != nil {
 		fmt.Fprintf(res, err.Error())
 		return
 	}
+	// The user token deletes the moved messages, so it is only taken from
+	// permitted users.
+	var accepted []slack.User
 	var output string
 	for _, user := range authedUsers {
-		slack.data = make(map[string]string)
-		slack.data["user"] = user.Id
-		slack.user.AccessToken = user.AccessToken
-		if err != nil {
-			fmt.Fprintf(res, err.Error())
-			return
+		if user.TokenType == "user" && !settings.IsPermittedUser(user.Id) {
+			fmt.Fprintln(os.Stderr, "User token of "+user.Id+" not saved, the user is not permitted")
+			audit("user_token_refused", "user "+user.Id+" is not permitted")
+			output += "The user token was not saved: only permitted users can install it.\n"
+			continue
+		}
+		accepted = append(accepted, user)
+		if user.RefreshToken == "" {
+			output += user.AccessToken + "\n"
 		}
-		output += user.AccessToken + "\n"
+	}
+	err = tokens.Install(accepted)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot save tokens: "+err.Error())
+	}
+	audit("installed", "new tokens for "+fmt.Sprint(len(accepted))+" users")
+	if output == "" {
+		output = "Choowie is installed. The tokens are rotated and saved by the bot."
 	}
 	fmt.Fprintf(res, output)
 }
@@ -158,76 +185,108 @@ func ShowAutomoves(res http.ResponseWriter, req *http.Request) {
 		fmt.Println(err.Error())
 		return
 	}
+	err = showAutomoves(req.Context(), q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error on PostMessage: "+err.Error())
+		return
+	}
+}
+
+func showAutomoves(ctx context.Context, q url.Values) error {
 	command := q.Get("text")
 	re := regexp.MustCompile(`\#[a-zA-Z0-9\-\_]{1,80}`)
 	fromto := re.FindAllString(command, 1)
 
-	var slack SlackRequest
-	slack.user = User{Id: q.Get("user_id"), TeamId: q.Get("team_id")}
-	settings.User = slack.user
-
-	slack.data = make(map[string]string)
+	settings.User = slack.User{Id: q.Get("user_id"), TeamId: q.Get("team_id")}
 
-	slack.data["channel"] = q.Get("channel_id")
-	slack.data["user"] = q.Get("user_id")
+	msg := slack.PostMessageRequest{
+		Channel: q.Get("channel_id"),
+		User:    q.Get("user_id"),
+	}
 	for _, move := range settings.Automoves {
+		line := "from <#" + move.From + "> to <#" + move.To + "> on :" + move.Trigger + ":"
+		if mode := move.mode(); mode != modeMove {
+			line += " (" + mode + ")"
+		}
+		if reason := move.paused(); reason != "" {
+			line += " (paused: " + reason + ")"
+		}
 		if len(fromto) == 1 && (move.From == strings.TrimPrefix(fromto[0], "#") || move.To == strings.TrimPrefix(fromto[0], "#")) {
-			slack.data["text"] += "from <#" + move.From + "> to <#" + move.To + "> on :" + move.Trigger + ":\n"
+			msg.Text += line + "\n"
 		}
 		if len(fromto) == 0 {
-			slack.data["text"] += "from <#" + move.From + "> to <#" + move.To + "> on :" + move.Trigger + ":\n"
+			msg.Text += line + "\n"
 		}
 	}
-	if len(slack.data["text"]) == 0 {
-		slack.data["text"] = "No automoves found"
+	if len(msg.Text) == 0 {
+		msg.Text = "No automoves found"
 	} else {
-		slack.data["text"] = "Automoves:\n" + slack.data["text"]
+		msg.Text = "Automoves:\n" + msg.Text
+	}
+	_, err := api.PostMessage(ctx, msg, true)
+	return err
+}
+
+func reviewReactions(ctx context.Context, channel, reaction, ts string) {
+	if voting.Result(ts) > 0 {
+		return
 	}
-	_, err = slack.PostMessage(true)
+	m, err := api.RetrieveMessage(ctx, channel, ts)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error on PostMessage: "+err.Error())
+		fmt.Fprintln(os.Stderr, "Cannot retrieve affected message: "+err.Error())
 		return
 	}
-}
-
-func CallbackHandler(res http.ResponseWriter, req *http.Request) {
-
-	reviewReactions := func(channel, reaction, ts string) {
-		if voting.Result(ts) > 0 {
-			return
-		}
-		var slack SlackRequest
-		slack.data = make(map[string]string)
-		slack.data["channel"] = channel
-		slack.data["latest"] = ts
-		m, err := slack.RetrieveMessage()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Cannot retrieve affected message: "+err.Error())
-			return
-		}
-		for _, r := range m.Reactions {
-			if r.Name == reaction {
-				for _, u := range r.Users {
-					if settings.IsPermittedUser(u) {
-						voting.Vote(ts)
-					}
+	for _, r := range m.Reactions {
+		if r.Name == reaction {
+			for _, u := range r.Users {
+				if settings.IsPermittedUser(u) {
+					voting.Vote(ts)
 				}
 			}
 		}
-		voting.UnVote(ts)
 	}
+	voting.UnVote(ts)
+}
+
+func notifyMoveFailure(ctx context.Context, move Automove, err error) {
+	notify(ctx, move, "Cannot move the thread to <#"+move.To+">: "+describe(err))
+}
+
+// notify tells the user who triggered the move about it with an ephemeral
+// message in the source channel.
+func notify(ctx context.Context, move Automove, text string) {
+	if move.User.Id == "" {
+		return
+	}
+	_, err := api.PostMessage(ctx, slack.PostMessageRequest{
+		Channel: move.From,
+		User:    move.User.Id,
+		Text:    text,
+	}, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot notify about the move: "+err.Error())
+	}
+}
 
+func CallbackHandler(res http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
 		log.Fatalln(err)
 	}
 	if len(req.Header["X-Slack-Signature"]) == 0 || !isVerified(req.Header, body, req.Header["X-Slack-Signature"][0]) {
-		fmt.Fprintln(os.Stderr, "Callback verification failed")
+		fmt.Fprintln(os.Stderr, "slack.Callback verification failed")
 		return
 	}
+	replayed := req.Header.Get(replayHeader)
+	if replayed == "" {
+		err = recorder.Save(req.Header, body)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+	}
 
-	var callback Callback
+	var callback slack.Callback
 	err = json.Unmarshal(body, &callback)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -240,6 +299,53 @@ func CallbackHandler(res http.ResponseWriter, req *http.Request) {
 		fmt.Fprintf(res, resJson)
 		return
 	}
+	if replayed != "" {
+		fmt.Fprintln(os.Stderr, "Replaying event "+callback.EventId+" from "+replayed)
+		callback.EventId = ""
+	}
+	if retry := req.Header.Get("X-Slack-Retry-Num"); retry != "" {
+		fmt.Fprintln(os.Stderr, "Event "+callback.EventId+" redelivered, attempt "+retry+": "+req.Header.Get("X-Slack-Retry-Reason"))
+	}
+	// Slack expects an answer within 3 seconds, so the event is handled
+	// after the ack.
+	moves.Add(1)
+	go func() {
+		defer moves.Done()
+		handleEvent(appCtx, callback)
+	}()
+}
+
+func handleEvent(ctx context.Context, callback slack.Callback) {
+	if events.Seen(callback.EventId) {
+		fmt.Fprintln(os.Stderr, "Event "+callback.EventId+" was already handled, skipping")
+		return
+	}
+	if callback.Event.Type == "message" {
+		syncMessage(ctx, callback.Event)
+		return
+	}
+
+	if callback.Event.Type == "app_mention" {
+		handleMention(ctx, callback)
+		return
+	}
+
+	if callback.Event.Type == "app_uninstalled" || callback.Event.Type == "tokens_revoked" {
+		handleLifecycle(ctx, callback)
+		return
+	}
+
+	switch callback.Event.Type {
+	case "channel_archive", "channel_unarchive", "channel_deleted", "channel_rename",
+		"group_archive", "group_unarchive", "group_deleted", "group_rename":
+		handleChannelEvent(ctx, callback.Event)
+		return
+	}
+
+	if callback.Event.Type == "member_joined_channel" {
+		handleMemberJoined(ctx, callback)
+		return
+	}
 
 	if callback.Event.Type == "reaction_removed" {
 		fmt.Fprintln(os.Stderr, "Event callback received: reaction "+callback.Event.Reaction+" was removed for  message "+callback.Event.Item.Ts)
@@ -249,7 +355,7 @@ func CallbackHandler(res http.ResponseWriter, req *http.Request) {
 				if settings.NecessaryVotes == 0 {
 					return
 				}
-				err = voting.UnVote(callback.Event.Item.Ts)
+				err := voting.UnVote(callback.Event.Item.Ts)
 				if err != nil {
 					fmt.Fprintln(os.Stderr, "Cannot unvote. "+err.Error())
 				}
@@ -257,15 +363,26 @@ func CallbackHandler(res http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	if callback.Event.Type == "reaction_added" && callback.Event.Reaction == undoReaction {
+		if record, ok := records.ByCopy(callback.Event.Item.Channel, callback.Event.Item.Ts); ok {
+			requestUndo(ctx, record, slack.User{Id: callback.Event.User, TeamId: callback.TeamId})
+			return
+		}
+	}
+
 	if callback.Event.Type == "reaction_added" {
 		fmt.Fprintln(os.Stderr, "Event callback received: reaction "+callback.Event.Reaction+" on message "+callback.Event.Item.Ts)
 		for _, move := range settings.Automoves {
 
 			if move.Trigger == callback.Event.Reaction && move.From == callback.Event.Item.Channel && settings.IsPermittedUser(callback.Event.User) {
+				if reason := move.paused(); reason != "" {
+					fmt.Fprintln(os.Stderr, "Automove to "+move.To+" is paused: "+reason)
+					continue
+				}
 				fmt.Fprintln(os.Stderr, "Necessary votes: "+strconv.Itoa(settings.NecessaryVotes)+", current votes counter: "+strconv.Itoa(voting.Result(callback.Event.Item.Ts)))
 
 				if settings.NecessaryVotes > 0 {
-					reviewReactions(move.From, move.Trigger, callback.Event.Item.Ts)
+					reviewReactions(ctx, move.From, move.Trigger, callback.Event.Item.Ts)
 					voting.Vote(callback.Event.Item.Ts)
 					fmt.Fprintln(os.Stderr, "After previous checking, current votes counter: "+strconv.Itoa(voting.Result(callback.Event.Item.Ts)))
 
@@ -275,12 +392,13 @@ func CallbackHandler(res http.ResponseWriter, req *http.Request) {
 				}
 				fmt.Fprintln(os.Stderr, "Event ts: "+callback.Event.EventTs+": Reaction "+callback.Event.Reaction+" is trigger. Start automove.")
 				voting.Cancel(callback.Event.Item.Ts)
-				go func() {
-					err = move.Do(callback.Event.Item.Ts)
-					if err != nil {
-						fmt.Fprintln(os.Stderr, err.Error())
-					}
-				}()
+				err := queue.Add(Job{Move: move, User: callback.Event.User, TeamId: callback.TeamId, Ts: callback.Event.Item.Ts})
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err.Error())
+					move := move
+					move.User = slack.User{Id: callback.Event.User, TeamId: callback.TeamId}
+					notifyMoveFailure(ctx, move, err)
+				}
 			}
 		}
 	}
@@ -297,13 +415,90 @@ func main() {
 	slackClientSecret = settings.SlackClientSecret
 	slackClientID = settings.SlackClientId
 	slackAppID = settings.SlackAppId
+	setTimeouts(&settings)
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		err = replay(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	httpClient, err := makeHTTPClient(&settings)
+	if err != nil {
+		panic("Cannot configure HTTP client: " + err.Error())
+	}
+	tokens, err = loadTokens(&settings)
+	if err != nil {
+		panic("Cannot load tokens: " + err.Error())
+	}
+	api = makeSlackClient(&settings, httpClient)
+	queue, err = openQueue(filepath.Join(settings.dataDir(), "queue"))
+	if err != nil {
+		panic("Cannot open the move queue: " + err.Error())
+	}
+	records, err = loadMoveStore(filepath.Join(settings.dataDir(), "moves.json"))
+	if err != nil {
+		panic("Cannot load the moves: " + err.Error())
+	}
+	channels, err = loadChannelStore(filepath.Join(settings.dataDir(), "channels.json"))
+	if err != nil {
+		panic("Cannot load the channels: " + err.Error())
+	}
+	if settings.RecordDir != "" && settings.SocketMode {
+		fmt.Fprintln(os.Stderr, "record_dir is ignored in Socket Mode, callbacks are only recorded and replayed over HTTP")
+	} else if settings.RecordDir != "" {
+		recorder, err = openRecorder(settings.RecordDir)
+		if err != nil {
+			panic("Cannot open record_dir: " + err.Error())
+		}
+		fmt.Fprintln(os.Stderr, "Recording callbacks to "+settings.RecordDir)
+	}
 
 	http.HandleFunc("/oAuth", OAuth)
 	http.HandleFunc("/showautomoves", ShowAutomoves)
-	http.Handle("/setup", http.RedirectHandler("https://slack.com/oauth/v2/authorize?user_scope=chat:write&client_id="+slackClientID+"&redirect_uri="+settings.SlackBotURL+"/oAuth", http.StatusSeeOther))
+	http.HandleFunc("/interactive", InteractiveHandler)
+	http.HandleFunc("/setup", Setup)
 	http.HandleFunc("/", CallbackHandler)
 	voting = makeVoting()
-	fmt.Fprintln(os.Stderr, "Slackbot started!")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	events = makeDeduplicator(dedupWindow)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	appCtx = ctx
+	err = tokens.resolveOwners(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+	go tokens.keepFresh(ctx)
+	queue.Run(ctx, moveWorkers, runJob)
+
+	if settings.SocketMode {
+		fmt.Fprintln(os.Stderr, "Slackbot started in Socket Mode!")
+		socketMode := &slack.SocketMode{
+			API:           api,
+			Transport:     httpClient.Transport,
+			OnEvent:       handleEvent,
+			OnCommand:     handleSlashCommand,
+			OnInteractive: handleInteraction,
+		}
+		socketMode.Run(ctx)
+		moves.Wait()
+		return
+	}
 
+	server := &http.Server{Addr: ":8080"}
+	go func() {
+		<-ctx.Done()
+		fmt.Fprintln(os.Stderr, "Shutting down, cancelling running moves")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	fmt.Fprintln(os.Stderr, "Slackbot started!")
+	err = server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	moves.Wait()
 }
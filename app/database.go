@@ -2,24 +2,50 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"sync"
+
+	"github.com/aageorg/slackbot_prod/slack"
 )
 
 type Database struct {
-	mu                sync.Mutex `json:"-"`
-	User              User       `json:"-"`
-	SlackSignSecret   string     `json:"slack_sign_secret"`
-	SlackClientSecret string     `json:"slack_client_secret"`
-	SlackClientId     string     `json:"slack_client_id"`
-	SlackAppId        string     `json:"slack_app_id"`
-	SlackUserToken    string     `json:"slack_user_token"`
-	SlackBotToken     string     `json:"slack_bot_token"`
-	SlackBotURL       string     `json:"slack_bot_url"`
-	NecessaryVotes    int        `json:"necessary_votes"`
-	NoRemove          bool       `json:"no_remove"`
-	PermittedUsers    []string   `json:"permitted_users"`
-	Automoves         []Automove `json:"automoves"`
+	mu                    sync.Mutex `json:"-"`
+	User                  slack.User `json:"-"`
+	SlackSignSecret       string     `json:"slack_sign_secret"`
+	SlackClientSecret     string     `json:"slack_client_secret"`
+	SlackClientId         string     `json:"slack_client_id"`
+	SlackAppId            string     `json:"slack_app_id"`
+	SlackUserToken        string     `json:"slack_user_token"`
+	SlackBotToken         string     `json:"slack_bot_token"`
+	SlackAppToken         string     `json:"slack_app_token"`
+	SlackBotRefreshToken  string     `json:"slack_bot_refresh_token"`
+	SlackUserRefreshToken string     `json:"slack_user_refresh_token"`
+	SlackBotURL           string     `json:"slack_bot_url"`
+	SocketMode            bool       `json:"socket_mode"`
+	DataDir               string     `json:"data_dir"`
+	AdminChannel          string     `json:"admin_channel"`
+	SlackAPIURL           string     `json:"slack_api_url"`
+	SlackFilesHost        string     `json:"slack_files_host"`
+	HTTPTimeout           int        `json:"http_timeout"`
+	CallTimeout           int        `json:"call_timeout"`
+	FileTimeout           int        `json:"file_timeout"`
+	MoveTimeout           int        `json:"move_timeout"`
+	DedupWindow           int        `json:"dedup_window"`
+	MoveWorkers           int        `json:"move_workers"`
+	SyncWindow            int        `json:"sync_window"`
+	RecordDir             string     `json:"record_dir"`
+	RecordLimit           int        `json:"record_limit"`
+	HTTPProxy             string     `json:"http_proxy"`
+	CAFile                string     `json:"ca_file"`
+	NecessaryVotes        int        `json:"necessary_votes"`
+	NoRemove              bool       `json:"no_remove"`
+	NoTombstone           bool       `json:"no_tombstone"`
+	Tombstone             string     `json:"tombstone"`
+	UndoReaction          string     `json:"undo_reaction"`
+	UndoWindow            int        `json:"undo_window"`
+	PermittedUsers        []string   `json:"permitted_users"`
+	Automoves             []Automove `json:"automoves"`
 }
 
 func (db *Database) IsPermittedUser(user string) bool {
@@ -88,15 +114,20 @@ func (db *Database) LoadConfig() error {
 	if err != nil {
 		return err
 	}
+	for _, a := range db.Automoves {
+		switch a.Mode {
+		case "", modeMove, modeCopy, modeLink:
+		default:
+			return fmt.Errorf("Unknown mode %q of the automove from %s to %s, use move, copy or link", a.Mode, a.From, a.To)
+		}
+	}
 	return nil
 }
 
-func (db *Database) getBotToken() string {
-	return db.SlackBotToken
-
-}
-
-func (db *Database) getUserToken() string {
-	return db.SlackUserToken
-
+// dataDir is where the bot keeps the state it changes at run time.
+func (db *Database) dataDir() string {
+	if db.DataDir == "" {
+		return "."
+	}
+	return db.DataDir
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+var dedupWindow = time.Hour
+
+// Deduplicator remembers the event_id of recent callbacks, so an event
+// Slack delivers again is handled only once. Slack retries within minutes,
+// IDs older than the window are forgotten.
+type Deduplicator struct {
+	seen   map[string]time.Time
+	window time.Duration
+	mu     sync.Mutex
+}
+
+func makeDeduplicator(window time.Duration) Deduplicator {
+	return Deduplicator{
+		seen:   make(map[string]time.Time),
+		window: window,
+	}
+}
+
+// Seen records the event and reports whether it was recorded before.
+func (d *Deduplicator) Seen(event_id string) bool {
+	if event_id == "" {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for id, t := range d.seen {
+		if now.Sub(t) > d.window {
+			delete(d.seen, id)
+		}
+	}
+	if _, ok := d.seen[event_id]; ok {
+		return true
+	}
+	d.seen[event_id] = now
+	return false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+// remedy explains what an admin can do about a Slack error.
+func remedy(e *slack.Error) string {
+	switch e.Code {
+	case "missing_scope":
+		return "The Slack app is missing the " + e.Needed + " scope (it has " + e.Provided + "). Add it to the app manifest and reinstall the app via " + settings.SlackBotURL + "/setup."
+	case "invalid_auth", "not_authed", "token_revoked", "token_expired", "account_inactive":
+		return "The Slack token used for " + e.Method + " is not valid anymore. Reinstall the app via " + settings.SlackBotURL + "/setup and update the tokens."
+	case "not_in_channel":
+		return "The bot is not a member of the channel. Invite it to the channel with /invite."
+	case "channel_not_found":
+		return "The channel does not exist or is private and the bot is not a member. Check the automove rule or invite the bot."
+	case "is_archived":
+		return "The channel is archived. Unarchive it or change the automove rule."
+	case "msg_too_long":
+		return "The message is too long for Slack to accept."
+	case "cant_delete_message":
+		return "The user token cannot delete messages of other people. Install the app via " + settings.SlackBotURL + "/setup as a workspace admin or set no_remove."
+	case "ratelimited":
+		return "Slack keeps rate limiting " + e.Method + ". Try again later."
+	}
+	return "Slack rejected " + e.Method + " with " + e.Code + "."
+}
+
+// describe turns an error into a message for the people who triggered the
+// move, with a remedy when Slack told us what went wrong.
+func describe(err error) string {
+	var slackErr *slack.Error
+	if errors.As(err, &slackErr) {
+		return err.Error() + "\n" + remedy(slackErr)
+	}
+	return err.Error()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+var moveTimeout = 30 * time.Minute
+
+func setTimeouts(db *Database) {
+	if db.MoveTimeout > 0 {
+		moveTimeout = time.Duration(db.MoveTimeout) * time.Second
+	}
+	if db.MoveWorkers > 0 {
+		moveWorkers = db.MoveWorkers
+	}
+	if db.SyncWindow > 0 {
+		syncWindow = time.Duration(db.SyncWindow) * time.Second
+	}
+	if db.DedupWindow > 0 {
+		dedupWindow = time.Duration(db.DedupWindow) * time.Second
+	}
+	if db.UndoWindow > 0 {
+		undoWindow = time.Duration(db.UndoWindow) * time.Second
+	}
+	if db.UndoReaction != "" {
+		undoReaction = strings.Trim(db.UndoReaction, ":")
+	}
+	if db.RecordLimit > 0 {
+		recordLimit = db.RecordLimit
+	}
+}
+
+func makeHTTPClient(db *Database) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if db.HTTPProxy != "" {
+		proxy, err := url.Parse(db.HTTPProxy)
+		if err != nil {
+			return nil, errors.New("Invalid http_proxy: " + err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if db.CAFile != "" {
+		pem, err := os.ReadFile(db.CAFile)
+		if err != nil {
+			return nil, errors.New("Cannot read ca_file: " + err.Error())
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in " + db.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(db.HTTPTimeout) * time.Second,
+	}, nil
+}
+
+// makeSlackClient builds the Web API client from the settings. Tokens are
+// looked up on every call, so a refresh or reinstall takes effect right away.
+func makeSlackClient(db *Database, client *http.Client) *slack.Client {
+	return &slack.Client{
+		APIURL:      db.SlackAPIURL,
+		FilesHost:   db.SlackFilesHost,
+		HTTPClient:  client,
+		Limiter:     slack.NewRateLimiter(),
+		CallTimeout: time.Duration(db.CallTimeout) * time.Second,
+		FileTimeout: time.Duration(db.FileTimeout) * time.Second,
+		BotToken:    tokens.BotToken,
+		UserToken:   tokens.UserToken,
+		AppToken:    slack.StaticToken(db.SlackAppToken),
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+const moveThreadCallback = "move_thread"
+
+// threadRef is the private metadata of the move dialog: the thread the
+// shortcut was used on.
+type threadRef struct {
+	Channel string `json:"channel"`
+	Ts      string `json:"ts"`
+}
+
+func InteractiveHandler(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(req.Header["X-Slack-Signature"]) == 0 || !isVerified(req.Header, body, req.Header["X-Slack-Signature"][0]) {
+		fmt.Fprintln(os.Stderr, "Interactive payload verification failed")
+		res.WriteHeader(http.StatusForbidden)
+		return
+	}
+	q, err := url.ParseQuery(string(body))
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	response := handleInteraction(req.Context(), json.RawMessage(q.Get("payload")))
+	if response == nil {
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(response)
+}
+
+// handleInteraction handles the "Move thread…" shortcut and its dialog. The
+// returned value is the reply to Slack, e.g. errors to show in the dialog.
+func handleInteraction(ctx context.Context, payload json.RawMessage) any {
+	var interaction slack.Interaction
+	err := json.Unmarshal(payload, &interaction)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot parse interactive payload: "+err.Error())
+		return nil
+	}
+	switch interaction.Type {
+	case "message_action":
+		if interaction.CallbackId != moveThreadCallback {
+			return nil
+		}
+		moves.Add(1)
+		go func() {
+			defer moves.Done()
+			openMoveDialog(appCtx, interaction)
+		}()
+	case "view_submission":
+		if interaction.View.CallbackId != moveThreadCallback {
+			return nil
+		}
+		return submitMoveDialog(appCtx, interaction)
+	}
+	return nil
+}
+
+func openMoveDialog(ctx context.Context, interaction slack.Interaction) {
+	thread := threadRef{Channel: interaction.Channel.Id, Ts: interaction.Message.ThreadTs}
+	if thread.Ts == "" {
+		thread.Ts = interaction.Message.Ts
+	}
+	move := Automove{From: thread.Channel, User: slack.User{Id: interaction.User.Id, TeamId: interaction.Team.Id}}
+	if !settings.IsPermittedUser(interaction.User.Id) {
+		notify(ctx, move, "You are not permitted to move threads.")
+		return
+	}
+	metadata, _ := json.Marshal(thread)
+	err := api.OpenView(ctx, interaction.TriggerId, moveDialog(string(metadata)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot open the move dialog: "+describe(err))
+	}
+}
+
+func moveDialog(metadata string) slack.View {
+	plain := func(text string) *slack.Element {
+		return &slack.Element{Type: "plain_text", Text: text}
+	}
+	moveOption := slack.Option{Text: plain("Move, remove the original"), Value: modeMove}
+	copyOption := slack.Option{Text: plain("Copy, keep the original"), Value: modeCopy}
+	linkOption := slack.Option{Text: plain("Link, post a summary with a link"), Value: modeLink}
+	return slack.View{
+		Type:            "modal",
+		CallbackId:      moveThreadCallback,
+		PrivateMetadata: metadata,
+		Title:           plain("Move thread"),
+		Submit:          plain("Move"),
+		Close:           plain("Cancel"),
+		Blocks: []slack.Block{
+			{
+				Type:    "input",
+				BlockId: "destination",
+				Label:   plain("Destination"),
+				Element: &slack.InputElement{
+					Type:        "conversations_select",
+					ActionId:    "channel",
+					Placeholder: plain("Choose a channel"),
+					Filter:      &slack.ConversationFilter{Include: []string{"public", "private"}, ExcludeBotUsers: true},
+				},
+			},
+			{
+				Type:    "input",
+				BlockId: "mode",
+				Label:   plain("Mode"),
+				Element: &slack.InputElement{
+					Type:          "radio_buttons",
+					ActionId:      "mode",
+					Options:       []slack.Option{moveOption, copyOption, linkOption},
+					InitialOption: &moveOption,
+				},
+			},
+			{
+				Type:     "input",
+				BlockId:  "note",
+				Label:    plain("Note"),
+				Optional: true,
+				Element: &slack.InputElement{
+					Type:        "plain_text_input",
+					ActionId:    "note",
+					Multiline:   true,
+					Placeholder: plain("Posted in the moved thread"),
+				},
+			},
+		},
+	}
+}
+
+// submitMoveDialog queues the move chosen in the dialog. Invalid input is
+// returned as errors for the dialog, which then stays open.
+func submitMoveDialog(ctx context.Context, interaction slack.Interaction) any {
+	var thread threadRef
+	err := json.Unmarshal([]byte(interaction.View.PrivateMetadata), &thread)
+	if err != nil || thread.Channel == "" || thread.Ts == "" {
+		fmt.Fprintln(os.Stderr, "Move dialog without a thread")
+		return nil
+	}
+	view := interaction.View
+	move := Automove{
+		From: thread.Channel,
+		To:   view.Value("destination", "channel").SelectedConversation,
+		User: slack.User{Id: interaction.User.Id, TeamId: interaction.Team.Id},
+	}
+	if !settings.IsPermittedUser(interaction.User.Id) {
+		return dialogError("destination", "You are not permitted to move threads.")
+	}
+	if move.To == "" {
+		return dialogError("destination", "Choose a channel.")
+	}
+	if move.To == move.From {
+		return dialogError("destination", "The thread is in this channel already.")
+	}
+	if mode := view.Value("mode", "mode").SelectedOption; mode != nil {
+		move.Mode = mode.Value
+	}
+	err = queue.Add(Job{
+		Move:   move,
+		User:   move.User.Id,
+		TeamId: move.User.TeamId,
+		Ts:     thread.Ts,
+		Note:   view.Value("note", "note").Value,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return dialogError("destination", "Cannot queue the move: "+err.Error())
+	}
+	return nil
+}
+
+func dialogError(block_id string, text string) map[string]any {
+	return map[string]any{
+		"response_action": "errors",
+		"errors":          map[string]string{block_id: text},
+	}
+}
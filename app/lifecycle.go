@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+// handleLifecycle marks the tokens of an uninstalled app or revoked tokens
+// invalid. The automoves that need them are paused until the app is
+// reinstalled via /setup.
+func handleLifecycle(ctx context.Context, callback slack.Callback) {
+	var bot, user bool
+	var err error
+	switch callback.Event.Type {
+	case "app_uninstalled":
+		bot, user = true, true
+		err = tokens.RevokeAll()
+	case "tokens_revoked":
+		if callback.Event.Tokens == nil {
+			return
+		}
+		bot, user, err = tokens.Revoke(callback.Event.Tokens.Bot, callback.Event.Tokens.OAuth)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot save the revoked tokens: "+err.Error())
+	}
+	var revoked []string
+	if bot {
+		revoked = append(revoked, "bot token")
+	}
+	if user {
+		revoked = append(revoked, "user token")
+	}
+	if len(revoked) == 0 {
+		return
+	}
+	var paused []string
+	for _, move := range settings.Automoves {
+		if reason := move.paused(); reason != "" {
+			paused = append(paused, "<#"+move.From+"> to <#"+move.To+">")
+		}
+	}
+	audit(callback.Event.Type, "revoked "+strings.Join(revoked, " and ")+", paused "+fmt.Sprint(len(paused))+" automoves")
+	tellAdmins(ctx, "Slack revoked the "+strings.Join(revoked, " and ")+" of Choowie ("+callback.Event.Type+"). Paused automoves: "+strings.Join(paused, ", ")+". Reinstall the app via "+settings.SlackBotURL+"/setup to resume them.")
+}
+
+// paused tells why the rule cannot run right now, or "" when it can.
+func (a Automove) paused() string {
+	bot, user := tokens.Valid()
+	if !bot {
+		return "the bot token is not valid"
+	}
+	if !user && a.mode() == modeMove {
+		return "the user token is not valid"
+	}
+	if reason := channels.Get(a.From).unavailable(); reason != "" {
+		return "the source channel is " + reason
+	}
+	if reason := channels.Get(a.To).unavailable(); reason != "" {
+		return "the destination channel is " + reason
+	}
+	return ""
+}
+
+// tellAdmins posts to admin_channel, or to the permitted users directly
+// when no admin channel is set. Without a bot token it can only log.
+func tellAdmins(ctx context.Context, text string) {
+	fmt.Fprintln(os.Stderr, text)
+	if bot, _ := tokens.Valid(); !bot {
+		return
+	}
+	channels := settings.PermittedUsers
+	if settings.AdminChannel != "" {
+		channels = []string{settings.AdminChannel}
+	}
+	for _, channel := range channels {
+		_, err := api.PostMessage(ctx, slack.PostMessageRequest{Channel: channel, Text: text}, false)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot notify "+channel+": "+err.Error())
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+// mentionCommand matches "move to #channel", "copy to #channel" and "link
+// to #channel". Slack sends channel links as <#C123|name>.
+var mentionCommand = regexp.MustCompile(`(?i)\b(move|copy|link)\s+(?:to\s+)?<#([A-Z0-9]+)(?:\|[^>]*)?>`)
+
+var undoCommand = regexp.MustCompile(`(?i)\bundo\b`)
+
+// handleMention runs a move asked for by mentioning the bot in a thread,
+// with no automove rule needed.
+func handleMention(ctx context.Context, callback slack.Callback) {
+	event := callback.Event
+	move := Automove{From: event.Channel, User: slack.User{Id: event.User, TeamId: callback.TeamId}}
+	fmt.Fprintln(os.Stderr, "Event callback received: mention by "+event.User+" in "+event.Channel)
+	if !settings.IsPermittedUser(event.User) {
+		notify(ctx, move, "You are not permitted to move threads.")
+		return
+	}
+	if undoCommand.MatchString(event.Text) && event.ThreadTs != "" {
+		record, ok := records.ByCopy(event.Channel, event.ThreadTs)
+		if !ok {
+			notify(ctx, move, "This thread was not moved here, there is nothing to undo.")
+			return
+		}
+		requestUndo(ctx, record, move.User)
+		return
+	}
+	match := mentionCommand.FindStringSubmatch(event.Text)
+	if match == nil || event.ThreadTs == "" {
+		notify(ctx, move, "Mention me inside a thread with \"move to #channel\", \"copy to #channel\" or \"link to #channel\", or with \"undo\" inside a moved one.")
+		return
+	}
+	move.To = match[2]
+	move.Mode = strings.ToLower(match[1])
+	if move.To == move.From {
+		notify(ctx, move, "The thread is in <#"+move.To+"> already.")
+		return
+	}
+	job := Job{Move: move, User: event.User, TeamId: callback.TeamId, Ts: event.ThreadTs}
+	if event.Ts != event.ThreadTs {
+		// The command is left out of the copy.
+		job.Skip = event.Ts
+	}
+	err := queue.Add(job)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		notifyMoveFailure(ctx, move, err)
+	}
+}
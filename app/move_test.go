@@ -0,0 +1,564 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aageorg/slackbot_prod/slack"
+	"github.com/aageorg/slackbot_prod/slacktest"
+)
+
+const testSecret = "test-secret"
+
+// startBot points the bot at a fake Slack with one automove from C1 to C2,
+// triggered by :white_check_mark: from U1, and starts the move workers.
+func startBot(t *testing.T, mode string) *slacktest.Server {
+	t.Helper()
+	fake := newFake(t)
+	runBot(t, fake, t.TempDir(), mode)
+	return fake
+}
+
+func newFake(t *testing.T) *slacktest.Server {
+	t.Helper()
+	fake := slacktest.NewServer()
+	t.Cleanup(fake.Close)
+	fake.AddUser(slacktest.User{Id: "U1", TeamId: "T1", RealName: "Jane"})
+	fake.AddUser(slacktest.User{Id: "U2", TeamId: "T1", RealName: "John"})
+	fake.AddMessage("C2", slacktest.Message{User: "U2", Text: "triage"})
+	return fake
+}
+
+// runBot starts the bot on the state in dir. The returned function stops it
+// the way a shutdown does, so that a test can start it again on the same dir.
+func runBot(t *testing.T, fake *slacktest.Server, dir string, mode string) (stop func()) {
+	t.Helper()
+	settings = Database{
+		DataDir:         dir,
+		SlackSignSecret: testSecret,
+		PermittedUsers:  []string{"U1"},
+		Automoves:       []Automove{{From: "C1", To: "C2", Trigger: "white_check_mark", Mode: mode}},
+	}
+	slackSignSecret = testSecret
+	setTimeouts(&settings)
+	tokens = &TokenStore{
+		path: filepath.Join(dir, "tokens.json"),
+		Bot:  Token{AccessToken: "xoxb-test", UserId: "UBOT"},
+		User: Token{AccessToken: "xoxp-test", UserId: "U1"},
+	}
+	api = &slack.Client{APIURL: fake.APIURL(), FilesHost: fake.URL, BotToken: tokens.BotToken, UserToken: tokens.UserToken}
+	var err error
+	queue, err = openQueue(filepath.Join(dir, "queue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err = loadMoveStore(filepath.Join(dir, "moves.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	channels, err = loadChannelStore(filepath.Join(dir, "channels.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder = nil
+	voting = makeVoting()
+	events = makeDeduplicator(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	appCtx = ctx
+	queue.Run(ctx, 1, runJob)
+	stop = func() {
+		cancel()
+		moves.Wait()
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+var eventCounter int
+
+// deliver sends an event to CallbackHandler the way Slack does.
+func deliver(t *testing.T, event map[string]any) {
+	t.Helper()
+	eventCounter++
+	body, err := json.Marshal(map[string]any{
+		"type":     "event_callback",
+		"team_id":  "T1",
+		"event_id": fmt.Sprintf("Ev%d", eventCounter),
+		"event":    event,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := httptest.NewRecorder()
+	CallbackHandler(res, slacktest.SignedRequest(testSecret, "/", body))
+	if res.Code != 200 {
+		t.Fatalf("CallbackHandler answered %d", res.Code)
+	}
+}
+
+func react(t *testing.T, user string, channel string, ts string) {
+	t.Helper()
+	deliver(t, map[string]any{
+		"type":     "reaction_added",
+		"user":     user,
+		"reaction": "white_check_mark",
+		"item":     map[string]any{"type": "message", "channel": channel, "ts": ts},
+		"event_ts": ts,
+	})
+}
+
+// waitFor polls until done holds, for the handler goroutine and the queue
+// worker to get through the move.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func moveCount() int {
+	records.mu.Lock()
+	defer records.mu.Unlock()
+	return len(records.Moves)
+}
+
+func queueEmpty(t *testing.T) bool {
+	entries, err := os.ReadDir(queue.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries) == 0
+}
+
+func moved(t *testing.T, n int) func() bool {
+	return func() bool {
+		return moveCount() == n && queueEmpty(t)
+	}
+}
+
+func userMessages(messages []slacktest.Message) []slacktest.Message {
+	var mm []slacktest.Message
+	for _, m := range messages {
+		if m.BotId == "" && m.Subtype != "tombstone" {
+			mm = append(mm, m)
+		}
+	}
+	return mm
+}
+
+func copiedThread(t *testing.T, fake *slacktest.Server) []slacktest.Message {
+	t.Helper()
+	for _, m := range fake.Messages("C2") {
+		if m.BotId != "" && (m.ThreadTs == "" || m.ThreadTs == m.Ts) {
+			return fake.Thread("C2", m.Ts)
+		}
+	}
+	t.Fatal("No copy in C2")
+	return nil
+}
+
+func TestMoveThread(t *testing.T) {
+	fake := startBot(t, modeMove)
+	root := fake.AddMessage("C1", slacktest.Message{User: "U2", Text: "The build is red"})
+	fake.AddMessage("C1", slacktest.Message{User: "U2", ThreadTs: root, Text: "Since this morning"})
+	fake.AddMessage("C1", slacktest.Message{User: "U1", ThreadTs: root, Text: "Looking"})
+
+	react(t, "U1", "C1", root)
+	waitFor(t, "the move", moved(t, 1))
+
+	thread := copiedThread(t, fake)
+	want := []string{"The build is red", "Since this morning", "Looking"}
+	if len(thread) != len(want) {
+		t.Fatalf("Copied %d messages, want %d: %+v", len(thread), len(want), thread)
+	}
+	for i, m := range thread {
+		if !strings.HasPrefix(m.Text, ">"+want[i]) {
+			t.Errorf("Copy %d = %q, want it to quote %q", i, m.Text, want[i])
+		}
+	}
+	if left := userMessages(fake.Messages("C1")); len(left) != 0 {
+		t.Errorf("The originals were not deleted: %+v", left)
+	}
+	if calls := fake.Calls("chat.delete"); len(calls) == 0 || calls[0].Token != "xoxp-test" {
+		t.Errorf("The originals were not deleted with the user token: %+v", calls)
+	}
+}
+
+func TestMoveThreadWithFiles(t *testing.T) {
+	for _, late := range []bool{false, true} {
+		t.Run(fmt.Sprintf("late shares %v", late), func(t *testing.T) {
+			fake := startBot(t, modeMove)
+			fake.LateShares = late
+			log := fake.AddFile("build.log", "text/plain", []byte("FAIL"))
+			shot := fake.AddFile("screen.png", "image/png", []byte("PNG"))
+			root := fake.AddMessage("C1", slacktest.Message{User: "U2", Text: "The build is red"})
+			// The first reply has files, the copy of its files is the second
+			// reply in the destination.
+			fake.AddMessage("C1", slacktest.Message{User: "U2", ThreadTs: root, Text: "Log attached", Files: []slacktest.File{log}})
+			fake.AddMessage("C1", slacktest.Message{User: "U1", ThreadTs: root, Text: "Screenshot", Files: []slacktest.File{shot}})
+			fake.AddMessage("C1", slacktest.Message{User: "U1", ThreadTs: root, Text: "Fixed"})
+
+			react(t, "U1", "C1", root)
+			waitFor(t, "the move", moved(t, 1))
+
+			thread := copiedThread(t, fake)
+			if len(thread) != 6 {
+				t.Fatalf("Copied %d messages, want 4 and 2 with the files: %+v", len(thread), thread)
+			}
+			for i, content := range map[int]string{2: "FAIL", 4: "PNG"} {
+				m := thread[i]
+				if m.Text != "Attached files:" || len(m.Files) != 1 {
+					t.Fatalf("Message %d = %+v, want the files of the message before", i, m)
+				}
+				if got := string(fake.FileContent(m.Files[0].Id)); got != content {
+					t.Errorf("File in message %d = %q, want %q", i, got, content)
+				}
+			}
+			if !strings.HasPrefix(thread[5].Text, ">Fixed") {
+				t.Errorf("Last copy = %q, want the last reply", thread[5].Text)
+			}
+			if left := userMessages(fake.Messages("C1")); len(left) != 0 {
+				t.Errorf("The originals were not deleted: %+v", left)
+			}
+		})
+	}
+}
+
+func TestMoveThreadWithAttachments(t *testing.T) {
+	fake := startBot(t, modeMove)
+	shared := json.RawMessage(`[{"fallback":"Deploy done","message_blocks":[{"team":"T1","channel":"C9","ts":"1.0","message":{"blocks":[{"type":"section","text":{"type":"mrkdwn","text":"Deploy done"}}]}}]}]`)
+	attached := fake.AddFile("report.csv", "text/csv", []byte("a,b"))
+	withFile, err := json.Marshal([]map[string]any{{"fallback": "report", "files": []slacktest.File{attached}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := fake.AddMessage("C1", slacktest.Message{User: "U2", Text: "Look at this", Attachments: shared})
+	fake.AddMessage("C1", slacktest.Message{User: "U2", ThreadTs: root, Text: "And the report", Attachments: withFile})
+
+	react(t, "U1", "C1", root)
+	waitFor(t, "the move", moved(t, 1))
+
+	thread := copiedThread(t, fake)
+	if len(thread) != 3 {
+		t.Fatalf("Copied %d messages, want 2 and the attached file: %+v", len(thread), thread)
+	}
+	if !bytes.Contains(thread[0].Attachments, []byte("Deploy done")) {
+		t.Errorf("The shared message was not copied: %s", thread[0].Attachments)
+	}
+	if len(thread[2].Files) != 1 || string(fake.FileContent(thread[2].Files[0].Id)) != "a,b" {
+		t.Errorf("The attached file was not copied: %+v", thread[2])
+	}
+	if left := userMessages(fake.Messages("C1")); len(left) != 0 {
+		t.Errorf("The originals were not deleted: %+v", left)
+	}
+}
+
+func TestMoveByMentionLeavesOutTheCommand(t *testing.T) {
+	fake := startBot(t, modeMove)
+	root := fake.AddMessage("C1", slacktest.Message{User: "U2", Text: "The build is red"})
+	fake.AddMessage("C1", slacktest.Message{User: "U2", ThreadTs: root, Text: "Since this morning"})
+	command := fake.AddMessage("C1", slacktest.Message{User: "U1", ThreadTs: root, Text: "<@UBOT> move to <#C2|triage>"})
+
+	deliver(t, map[string]any{
+		"type":      "app_mention",
+		"user":      "U1",
+		"channel":   "C1",
+		"ts":        command,
+		"thread_ts": root,
+		"text":      "<@UBOT> move to <#C2|triage>",
+		"event_ts":  command,
+	})
+	waitFor(t, "the move", moved(t, 1))
+
+	thread := copiedThread(t, fake)
+	if len(thread) != 2 {
+		t.Fatalf("Copied %d messages, want the thread without the command: %+v", len(thread), thread)
+	}
+	for _, m := range thread {
+		if strings.Contains(m.Text, "move to") {
+			t.Errorf("The command was copied: %q", m.Text)
+		}
+	}
+	if left := userMessages(fake.Messages("C1")); len(left) != 0 {
+		t.Errorf("The originals were not deleted: %+v", left)
+	}
+}
+
+// settled waits until the queued move ran, whatever its outcome.
+func settled(t *testing.T, fake *slacktest.Server) func() bool {
+	return func() bool {
+		return len(fake.Calls("conversations.replies")) > 0 && queueEmpty(t)
+	}
+}
+
+func TestMoveKeepsOriginalWhenFileCannotBeDownloaded(t *testing.T) {
+	fake := startBot(t, modeMove)
+	gone := slacktest.File{Id: "FGONE", Name: "gone.txt", MimeType: "text/plain", Size: 4, UrlPrivate: fake.URL + "/files/FGONE/gone.txt"}
+	root := fake.AddMessage("C1", slacktest.Message{User: "U2", Text: "The build is red"})
+	fake.AddMessage("C1", slacktest.Message{User: "U2", ThreadTs: root, Text: "Log attached", Files: []slacktest.File{gone}})
+
+	react(t, "U1", "C1", root)
+	waitFor(t, "the move", settled(t, fake))
+
+	if n := moveCount(); n != 0 {
+		t.Errorf("%d moves recorded for a failed move", n)
+	}
+	if left := userMessages(fake.Messages("C1")); len(left) != 2 {
+		t.Errorf("The originals were deleted: %+v", left)
+	}
+	if copies := fake.Messages("C2"); len(copies) != 1 {
+		t.Errorf("The partial copy was left in C2: %+v", copies)
+	}
+}
+
+func TestMoveResumesCopyAfterShutdown(t *testing.T) {
+	fake := newFake(t)
+	dir := t.TempDir()
+	stop := runBot(t, fake, dir, modeMove)
+	log := fake.AddFile("build.log", "text/plain", []byte("FAIL"))
+	root := fake.AddMessage("C1", slacktest.Message{User: "U2", Text: "The build is red"})
+	fake.AddMessage("C1", slacktest.Message{User: "U2", ThreadTs: root, Text: "Log attached", Files: []slacktest.File{log}})
+	fake.AddMessage("C1", slacktest.Message{User: "U1", ThreadTs: root, Text: "Fixed"})
+
+	// The bot shuts down while the files of the first reply are shared,
+	// after the copy of its text was posted.
+	stopped := make(chan struct{})
+	var completeUpload func(params map[string]any) (map[string]any, string)
+	completeUpload = fake.Handle("files.completeUploadExternal", func(params map[string]any) (map[string]any, string) {
+		fake.Handle("files.completeUploadExternal", completeUpload)
+		go func() {
+			stop()
+			close(stopped)
+		}()
+		time.Sleep(100 * time.Millisecond)
+		return nil, "internal_error"
+	})
+	react(t, "U1", "C1", root)
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the shutdown")
+	}
+	if n := moveCount(); n != 0 {
+		t.Fatalf("%d moves recorded for an interrupted move", n)
+	}
+	if copies := fake.Messages("C2"); len(copies) != 3 {
+		t.Fatalf("C2 has %d messages, want the root and the text of the reply copied: %+v", len(copies), copies)
+	}
+
+	runBot(t, fake, dir, modeMove)
+	waitFor(t, "the resumed move", moved(t, 1))
+	thread := copiedThread(t, fake)
+	want := []string{">The build is red", ">Log attached", "Attached files:", ">Fixed"}
+	if len(thread) != len(want) {
+		t.Fatalf("Copied %d messages, want %d: %+v", len(thread), len(want), thread)
+	}
+	for i, m := range thread {
+		if !strings.HasPrefix(m.Text, want[i]) {
+			t.Errorf("Copy %d = %q, want %q", i, m.Text, want[i])
+		}
+	}
+	if copies := fake.Messages("C2"); len(copies) != 1+len(want) {
+		t.Errorf("C2 has %d messages, want the copy once: %+v", len(copies), copies)
+	}
+	if left := userMessages(fake.Messages("C1")); len(left) != 0 {
+		t.Errorf("The originals were not deleted: %+v", left)
+	}
+}
+
+// failPost makes the nth post in the destination fail.
+func failPost(fake *slacktest.Server, n int32) {
+	var posts int32
+	var postMessage func(params map[string]any) (map[string]any, string)
+	postMessage = fake.Handle("chat.postMessage", func(params map[string]any) (map[string]any, string) {
+		if params["channel"] == "C2" && atomic.AddInt32(&posts, 1) == n {
+			return nil, "msg_too_long"
+		}
+		return postMessage(params)
+	})
+}
+
+func TestMoveRemovesPartialCopyWhenAReplyFails(t *testing.T) {
+	fake := startBot(t, modeMove)
+	root := fake.AddMessage("C1", slacktest.Message{User: "U2", Text: "The build is red"})
+	fake.AddMessage("C1", slacktest.Message{User: "U2", ThreadTs: root, Text: "Since this morning"})
+	fake.AddMessage("C1", slacktest.Message{User: "U1", ThreadTs: root, Text: "Looking"})
+	fake.AddMessage("C1", slacktest.Message{User: "U1", ThreadTs: root, Text: "Fixed"})
+	failPost(fake, 3)
+
+	react(t, "U1", "C1", root)
+	waitFor(t, "the move", settled(t, fake))
+
+	if n := moveCount(); n != 0 {
+		t.Errorf("%d moves recorded for a failed move", n)
+	}
+	if left := userMessages(fake.Messages("C1")); len(left) != 4 {
+		t.Errorf("The originals were deleted: %+v", left)
+	}
+	if copies := fake.Messages("C2"); len(copies) != 1 {
+		t.Errorf("The partial copy was left in C2: %+v", copies)
+	}
+	if calls := fake.Calls("chat.postEphemeral"); len(calls) != 1 {
+		t.Errorf("The user was told about the failure %d times, want once", len(calls))
+	}
+}
+
+func TestMoveMarksPartialCopyIncomplete(t *testing.T) {
+	fake := startBot(t, modeMove)
+	root := fake.AddMessage("C1", slacktest.Message{User: "U2", Text: "The build is red"})
+	fake.AddMessage("C1", slacktest.Message{User: "U2", ThreadTs: root, Text: "Since this morning"})
+	fake.AddMessage("C1", slacktest.Message{User: "U1", ThreadTs: root, Text: "Looking"})
+	failPost(fake, 3)
+	fake.Handle("chat.delete", func(params map[string]any) (map[string]any, string) {
+		return nil, "cant_delete_message"
+	})
+
+	react(t, "U1", "C1", root)
+	waitFor(t, "the move", settled(t, fake))
+
+	if n := moveCount(); n != 0 {
+		t.Errorf("%d moves recorded for a failed move", n)
+	}
+	if left := userMessages(fake.Messages("C1")); len(left) != 3 {
+		t.Errorf("The originals were deleted: %+v", left)
+	}
+	thread := copiedThread(t, fake)
+	if len(thread) != 3 {
+		t.Fatalf("C2 has %d messages of the copy, want 2 and the warning: %+v", len(thread), thread)
+	}
+	if warning := thread[2]; !strings.HasPrefix(warning.Text, ":warning: This copy is incomplete") {
+		t.Errorf("Last message of the partial copy = %q, want the warning", warning.Text)
+	}
+}
+
+func TestCopyFollowsDeletes(t *testing.T) {
+	fake := startBot(t, modeCopy)
+	root := fake.AddMessage("C1", slacktest.Message{User: "U2", Text: "The build is red"})
+	reply := fake.AddMessage("C1", slacktest.Message{User: "U2", ThreadTs: root, Text: "Wrong channel, sorry"})
+
+	react(t, "U1", "C1", root)
+	waitFor(t, "the copy", moved(t, 1))
+	if left := userMessages(fake.Messages("C1")); len(left) != 2 {
+		t.Fatalf("The originals of a copy were deleted: %+v", left)
+	}
+	if thread := copiedThread(t, fake); len(thread) != 2 {
+		t.Fatalf("Copied %d messages, want 2", len(thread))
+	}
+
+	deliver(t, map[string]any{
+		"type":       "message",
+		"subtype":    "message_deleted",
+		"channel":    "C1",
+		"deleted_ts": reply,
+		"event_ts":   reply,
+	})
+	waitFor(t, "the copy to be deleted", func() bool {
+		return len(copiedThread(t, fake)) == 1
+	})
+	if n := len(records.Copies("C1", reply, syncWindow)); n != 0 {
+		t.Errorf("%d copies of the deleted message are still recorded", n)
+	}
+}
+
+func TestCopyFollowsDeletesOfFiles(t *testing.T) {
+	fake := startBot(t, modeCopy)
+	log := fake.AddFile("build.log", "text/plain", []byte("FAIL"))
+	root := fake.AddMessage("C1", slacktest.Message{User: "U2", Text: "The build is red"})
+	reply := fake.AddMessage("C1", slacktest.Message{User: "U2", ThreadTs: root, Text: "Log attached", Files: []slacktest.File{log}})
+
+	react(t, "U1", "C1", root)
+	waitFor(t, "the copy", moved(t, 1))
+	thread := copiedThread(t, fake)
+	if len(thread) != 3 {
+		t.Fatalf("Copied %d messages, want 2 and the files", len(thread))
+	}
+	if record, ok := records.ByCopy("C2", thread[2].Ts); !ok || record.Ts != root {
+		t.Errorf("The reply with the files is not recorded as a copy: %+v", record)
+	}
+
+	deliver(t, map[string]any{
+		"type":       "message",
+		"subtype":    "message_deleted",
+		"channel":    "C1",
+		"deleted_ts": reply,
+		"event_ts":   reply,
+	})
+	waitFor(t, "the copy and its files to be deleted", func() bool {
+		return len(copiedThread(t, fake)) == 1
+	})
+	if _, ok := records.ByCopy("C2", thread[2].Ts); ok {
+		t.Error("The deleted reply with the files is still recorded")
+	}
+}
+
+func TestMoveResumesDeletionAfterShutdown(t *testing.T) {
+	fake := newFake(t)
+	dir := t.TempDir()
+	stop := runBot(t, fake, dir, modeMove)
+	root := fake.AddMessage("C1", slacktest.Message{User: "U2", Text: "The build is red"})
+	fake.AddMessage("C1", slacktest.Message{User: "U2", ThreadTs: root, Text: "Since this morning"})
+	fake.AddMessage("C1", slacktest.Message{User: "U1", ThreadTs: root, Text: "Looking"})
+
+	// The bot shuts down while the second original is deleted. Slack still
+	// deletes it, but the bot does not get the reply.
+	stopped := make(chan struct{})
+	var deletes int32
+	var deleteMessage func(params map[string]any) (map[string]any, string)
+	deleteMessage = fake.Handle("chat.delete", func(params map[string]any) (map[string]any, string) {
+		if atomic.AddInt32(&deletes, 1) == 2 {
+			go func() {
+				stop()
+				close(stopped)
+			}()
+			time.Sleep(100 * time.Millisecond)
+		}
+		return deleteMessage(params)
+	})
+	react(t, "U1", "C1", root)
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the shutdown")
+	}
+	if n := moveCount(); n != 0 {
+		t.Fatalf("%d moves recorded for an interrupted move", n)
+	}
+	if queueEmpty(t) {
+		t.Fatal("The interrupted move was not kept in the queue")
+	}
+
+	runBot(t, fake, dir, modeMove)
+	waitFor(t, "the resumed move", moved(t, 1))
+	if left := userMessages(fake.Messages("C1")); len(left) != 0 {
+		t.Errorf("The originals were not deleted: %+v", left)
+	}
+	if thread := copiedThread(t, fake); len(thread) != 3 {
+		t.Errorf("Copied %d messages, want 3: %+v", len(thread), thread)
+	}
+	if copies := fake.Messages("C2"); len(copies) != 4 {
+		t.Errorf("C2 has %d messages, want the copy once: %+v", len(copies), copies)
+	}
+	tombstones := 0
+	for _, m := range fake.Messages("C1") {
+		if m.BotId != "" {
+			tombstones++
+		}
+	}
+	if tombstones != 1 {
+		t.Errorf("%d tombstones in C1, want 1", tombstones)
+	}
+}
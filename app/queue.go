@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+var moveWorkers = 2
+
+// Job is a move waiting in the queue. Undo jobs move the copy of a thread
+// back. Skip is a reply that is not copied, the mention that asked for the
+// move.
+type Job struct {
+	Id     string    `json:"id"`
+	Move   Automove  `json:"move"`
+	User   string    `json:"user"`
+	TeamId string    `json:"team_id"`
+	Ts     string    `json:"ts"`
+	Note   string    `json:"note,omitempty"`
+	Skip   string    `json:"skip,omitempty"`
+	Undo   bool      `json:"undo,omitempty"`
+	Added  time.Time `json:"added"`
+}
+
+// Queue keeps pending moves as one file per job in the queue directory, so
+// a restart picks up the moves that did not finish. A job file is removed
+// once its move succeeded or failed for good.
+type Queue struct {
+	dir     string
+	pending []Job
+	wake    chan struct{}
+	mu      sync.Mutex
+}
+
+var queue *Queue
+
+func openQueue(dir string) (*Queue, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{dir: dir, wake: make(chan struct{}, 1)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var job Job
+		err = json.Unmarshal(data, &job)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot parse queued move "+entry.Name()+": "+err.Error())
+			continue
+		}
+		q.pending = append(q.pending, job)
+	}
+	if len(q.pending) > 0 {
+		fmt.Fprintln(os.Stderr, "Resuming "+strconv.Itoa(len(q.pending))+" queued moves")
+	}
+	return q, nil
+}
+
+// Add writes the job to disk before it is handed to a worker.
+func (q *Queue) Add(job Job) error {
+	job.Added = time.Now()
+	job.Id = strconv.FormatInt(job.Added.UnixNano(), 10) + "-" + strings.ReplaceAll(job.Ts, ".", "")
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	err = writeFile(q.path(job), data, 0600)
+	if err != nil {
+		return fmt.Errorf("Cannot queue the move: %w", err)
+	}
+	q.mu.Lock()
+	q.pending = append(q.pending, job)
+	q.mu.Unlock()
+	q.signal()
+	return nil
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) Done(job Job) error {
+	return os.Remove(q.path(job))
+}
+
+func (q *Queue) path(job Job) string {
+	return filepath.Join(q.dir, job.Id+".json")
+}
+
+func (q *Queue) next() (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return Job{}, false
+	}
+	job := q.pending[0]
+	q.pending = q.pending[1:]
+	if len(q.pending) > 0 {
+		// Wake another idle worker for the rest.
+		q.signal()
+	}
+	return job, true
+}
+
+// Run starts the workers. They stop when ctx is done, leaving the jobs in
+// progress on disk for the next start.
+func (q *Queue) Run(ctx context.Context, workers int, handle func(ctx context.Context, job Job)) {
+	for i := 0; i < workers; i++ {
+		moves.Add(1)
+		go func() {
+			defer moves.Done()
+			for {
+				job, ok := q.next()
+				if ok {
+					handle(ctx, job)
+					continue
+				}
+				select {
+				case <-q.wake:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// runJob moves a queued thread unless it is moving or was moved already. A
+// move cut short by shutdown stays queued.
+func runJob(ctx context.Context, job Job) {
+	if ctx.Err() != nil {
+		return
+	}
+	move := job.Move
+	move.User = slack.User{Id: job.User, TeamId: job.TeamId}
+	move.Note = job.Note
+	move.Skip = job.Skip
+	move.restore = job.Undo
+	if reason := move.paused(); reason != "" {
+		fmt.Fprintln(os.Stderr, "Move of "+job.Ts+" dropped, moves are paused: "+reason)
+		notify(ctx, move, "Cannot move the thread to <#"+move.To+">, moves are paused: "+reason+".")
+		err := queue.Done(job)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot remove the dropped move from the queue: "+err.Error())
+		}
+		return
+	}
+	done, err := records.Begin(move.From, job.Ts, move.To, move.mode() != modeMove)
+	switch {
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "Thread "+job.Ts+" is being moved already, skipping")
+		notify(ctx, move, "This thread is being moved already.")
+	case done != nil:
+		fmt.Fprintln(os.Stderr, "Thread "+job.Ts+" was already moved to "+done.To+", skipping")
+		notify(ctx, move, "This thread was already moved to <#"+done.To+">.")
+	case job.Undo:
+		err = move.undo(ctx, job.Ts)
+		if err != nil && ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "Undo of "+job.Ts+" interrupted, it is resumed on the next start")
+			return
+		}
+	default:
+		err = move.run(ctx, job.Ts)
+		if err != nil && ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "Move of "+job.Ts+" interrupted, it is resumed on the next start")
+			return
+		}
+	}
+	err = queue.Done(job)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot remove the finished move from the queue: "+err.Error())
+	}
+}
+
+// run does the move while the thread is locked and records it.
+func (a Automove) run(ctx context.Context, ts string) error {
+	moveCtx, cancel := context.WithTimeout(ctx, moveTimeout)
+	defer cancel()
+	record, err := a.Do(moveCtx, ts)
+	finishErr := records.Finish(a.From, ts, record)
+	if finishErr != nil {
+		fmt.Fprintln(os.Stderr, "Cannot record the move: "+finishErr.Error())
+	}
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintln(os.Stderr, describe(err))
+		notifyMoveFailure(ctx, a, err)
+	}
+	if record != nil && a.Note != "" {
+		_, noteErr := api.PostMessage(ctx, slack.PostMessageRequest{
+			Channel:  a.To,
+			ThreadTs: record.DestTs,
+			Text:     "Note from <@" + a.User.Id + ">: " + a.Note,
+		}, false)
+		if noteErr != nil {
+			fmt.Fprintln(os.Stderr, "Cannot post the note: "+noteErr.Error())
+		}
+	}
+	return err
+}
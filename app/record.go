@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// MoveRecord is a finished move. Copies maps the ts of every moved message
+// to the ts of its copy in the destination, or of the summary for a link.
+// Files maps a moved message with files to the reply its files were shared
+// in, which comes after the copy of its text. Kept is set when the originals stayed in the source, Tombstone is the ts
+// of the message that took the place of the root there.
+type MoveRecord struct {
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	Ts        string            `json:"ts"`
+	DestTs    string            `json:"dest_ts"`
+	User      string            `json:"user"`
+	Copies    map[string]string `json:"copies"`
+	Files     map[string]string `json:"files,omitempty"`
+	Mode      string            `json:"mode,omitempty"`
+	Kept      bool              `json:"kept"`
+	Tombstone string            `json:"tombstone,omitempty"`
+	Finished  time.Time         `json:"finished"`
+}
+
+// key identifies the move in moves.json. A thread is moved only once, but
+// it can be kept as a copy in several channels.
+func (r MoveRecord) key() string {
+	if r.Kept {
+		return threadKey(r.From, r.Ts) + ">" + r.To
+	}
+	return threadKey(r.From, r.Ts)
+}
+
+var errMoveRunning = errors.New("The thread is being moved already")
+
+// MoveStore locks threads while they move and remembers the finished moves
+// in moves.json, so no thread is copied twice to the same channel. The moves
+// are indexed by their messages in the source and their copies in the
+// destination. Moves that kept the originals are forgotten once they can
+// neither be synced nor undone anymore.
+type MoveStore struct {
+	mu      sync.Mutex
+	path    string
+	running map[string]bool
+	sources map[string][]string
+	copies  map[string]string
+	Moves   map[string]MoveRecord `json:"moves"`
+}
+
+var records *MoveStore
+
+func threadKey(channel string, ts string) string {
+	return channel + "/" + ts
+}
+
+func loadMoveStore(path string) (*MoveStore, error) {
+	s := &MoveStore{path: path, running: make(map[string]bool), sources: make(map[string][]string), copies: make(map[string]string)}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(data, s)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse %s: %w", path, err)
+		}
+	}
+	if s.Moves == nil {
+		s.Moves = make(map[string]MoveRecord)
+	}
+	for key, record := range s.Moves {
+		if key != record.key() {
+			delete(s.Moves, key)
+			s.Moves[record.key()] = record
+		}
+	}
+	for key, record := range s.Moves {
+		s.index(key, record)
+	}
+	if s.prune() {
+		err = s.save()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *MoveStore) index(key string, record MoveRecord) {
+	for source, dest := range record.Copies {
+		source := threadKey(record.From, source)
+		s.sources[source] = append(s.sources[source], key)
+		s.copies[threadKey(record.To, dest)] = key
+	}
+	for _, dest := range record.Files {
+		s.copies[threadKey(record.To, dest)] = key
+	}
+	if record.DestTs != "" {
+		s.copies[threadKey(record.To, record.DestTs)] = key
+	}
+}
+
+func (s *MoveStore) unindex(record MoveRecord) {
+	for source, dest := range record.Copies {
+		s.unindexSource(threadKey(record.From, source), record.key())
+		delete(s.copies, threadKey(record.To, dest))
+	}
+	for _, dest := range record.Files {
+		delete(s.copies, threadKey(record.To, dest))
+	}
+	delete(s.copies, threadKey(record.To, record.DestTs))
+}
+
+func (s *MoveStore) unindexSource(source string, key string) {
+	keys := s.sources[source]
+	for i, k := range keys {
+		if k == key {
+			keys = append(keys[:i:i], keys[i+1:]...)
+			break
+		}
+	}
+	if len(keys) == 0 {
+		delete(s.sources, source)
+	} else {
+		s.sources[source] = keys
+	}
+}
+
+// prune forgets the moves that kept the originals and finished longer ago
+// than both the sync and the undo window. It reports whether any was.
+func (s *MoveStore) prune() bool {
+	keep := syncWindow
+	if undoWindow > keep {
+		keep = undoWindow
+	}
+	pruned := false
+	for key, record := range s.Moves {
+		if record.Kept && time.Since(record.Finished) > keep {
+			s.unindex(record)
+			delete(s.Moves, key)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// Begin locks the thread for a move to a channel. It fails with
+// errMoveRunning, or with the record of the earlier move when the thread was
+// moved already or, for a move that keeps the original, was kept in the same
+// channel already.
+func (s *MoveStore) Begin(channel string, ts string, to string, kept bool) (*MoveRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := threadKey(channel, ts)
+	if record, ok := s.Moves[key]; ok {
+		return &record, nil
+	}
+	if record, ok := s.Moves[MoveRecord{From: channel, Ts: ts, To: to, Kept: true}.key()]; ok && kept {
+		return &record, nil
+	}
+	if s.running[key] {
+		return nil, errMoveRunning
+	}
+	s.running[key] = true
+	return nil, nil
+}
+
+// Finish unlocks the thread and, when something was copied, records the
+// move.
+func (s *MoveStore) Finish(channel string, ts string, record *MoveRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := threadKey(channel, ts)
+	delete(s.running, key)
+	if record == nil || len(record.Copies) == 0 {
+		return nil
+	}
+	record.Finished = time.Now()
+	s.Moves[record.key()] = *record
+	s.index(record.key(), *record)
+	s.prune()
+	return s.save()
+}
+
+// keptCopy is the copy of a message, made by a move that kept the original.
+// Files is the reply with the files of the message, if it had any.
+type keptCopy struct {
+	Record MoveRecord
+	Ts     string
+	Files  string
+}
+
+// Copies finds the copies of a message that was kept in the source, for
+// moves that finished within the window. Links have no copies.
+func (s *MoveStore) Copies(channel string, ts string, window time.Duration) []keptCopy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found []keptCopy
+	for _, key := range s.sources[threadKey(channel, ts)] {
+		record := s.Moves[key]
+		if !record.Kept || record.Mode == modeLink || time.Since(record.Finished) > window {
+			continue
+		}
+		if dest, ok := record.Copies[ts]; ok {
+			found = append(found, keptCopy{Record: record, Ts: dest, Files: record.Files[ts]})
+		}
+	}
+	return found
+}
+
+// ByCopy finds the move that made a message of a thread in the destination.
+func (s *MoveStore) ByCopy(channel string, ts string) (MoveRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.Moves[s.copies[threadKey(channel, ts)]]
+	return record, ok
+}
+
+// Undo forgets a move that was undone, so the thread can be moved again.
+func (s *MoveStore) Undo(record MoveRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unindex(record)
+	delete(s.Moves, record.key())
+	return s.save()
+}
+
+// Forget drops a message whose copy in a move was deleted.
+func (s *MoveStore) Forget(record MoveRecord, ts string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.Moves[record.key()]
+	if !ok {
+		return nil
+	}
+	s.unindexSource(threadKey(record.From, ts), record.key())
+	delete(s.copies, threadKey(record.To, record.Copies[ts]))
+	if files, ok := record.Files[ts]; ok {
+		delete(s.copies, threadKey(record.To, files))
+		delete(record.Files, ts)
+	}
+	delete(record.Copies, ts)
+	s.Moves[record.key()] = record
+	return s.save()
+}
+
+func (s *MoveStore) save() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path, data, 0600)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var recordLimit = 1000
+
+// replayHeader marks the callbacks sent by replay, so they are neither
+// recorded again nor skipped as already handled.
+const replayHeader = "X-Choowie-Replay"
+
+// Recording is a verified callback as Slack sent it.
+type Recording struct {
+	Received time.Time   `json:"received"`
+	Headers  http.Header `json:"headers"`
+	Body     string      `json:"body"`
+}
+
+// Recorder saves callbacks to a directory for replay, keeping only the
+// latest recordLimit of them.
+type Recorder struct {
+	mu  sync.Mutex
+	dir string
+}
+
+var recorder *Recorder
+
+func openRecorder(dir string) (*Recorder, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir}, nil
+}
+
+// Save writes the callback and removes the oldest recordings over the
+// limit. A nil Recorder saves nothing.
+func (r *Recorder) Save(headers http.Header, body []byte) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	data, err := json.MarshalIndent(Recording{Received: now, Headers: headers, Body: string(body)}, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d.json", now.UnixNano())
+	err = writeFile(filepath.Join(r.dir, name), data, 0600)
+	if err != nil {
+		return fmt.Errorf("Cannot record the callback: %w", err)
+	}
+	files, err := recordings(r.dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > recordLimit {
+		os.Remove(files[0])
+		files = files[1:]
+	}
+	return nil
+}
+
+// recordings lists the recordings in a directory, oldest first.
+func recordings(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// replay sends recorded callbacks to a running bot, signed anew with the
+// signing secret from the config, so they go through CallbackHandler again.
+// The bot handles them even when it handled the same events before.
+// Arguments are recordings or directories of them.
+func replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	target := flags.String("url", "http://localhost:8080/", "callback URL of the bot")
+	delay := flags.Duration("delay", 0, "pause between callbacks")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, arg := range flags.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		found, err := recordings(arg)
+		if err != nil {
+			return err
+		}
+		files = append(files, found...)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("Usage: %s replay [-url %s] [-delay 1s] recording-or-dir...", os.Args[0], *target)
+	}
+	for i, file := range files {
+		if i > 0 {
+			time.Sleep(*delay)
+		}
+		err := replayFile(*target, file)
+		if err != nil {
+			return fmt.Errorf("Cannot replay %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func replayFile(target string, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var recording Recording
+	err = json.Unmarshal(data, &recording)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", target, bytes.NewReader([]byte(recording.Body)))
+	if err != nil {
+		return err
+	}
+	for name, values := range recording.Headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Length", "Host", "Connection", "Accept-Encoding":
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	req.Header.Set(replayHeader, filepath.Base(file))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+GetHash([]byte("v0:"+timestamp+":"+recording.Body)))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	fmt.Fprintln(os.Stderr, "Replayed "+filepath.Base(file)+" recorded at "+recording.Received.Format(time.RFC3339)+": "+res.Status)
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const stateCookie = "choowie_oauth_state"
+const stateLifetime = 10 * time.Minute
+
+// oauthStates are the state nonces of OAuth flows started via /setup. A
+// callback to /oAuth is only accepted with one of them, from the browser
+// that started the flow.
+var oauthStates = struct {
+	mu     sync.Mutex
+	issued map[string]time.Time
+}{issued: make(map[string]time.Time)}
+
+// Setup starts the installation of the app in Slack.
+func Setup(res http.ResponseWriter, req *http.Request) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		http.Error(res, "Cannot start the installation", http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(nonce)
+	oauthStates.mu.Lock()
+	for s, issued := range oauthStates.issued {
+		if time.Since(issued) > stateLifetime {
+			delete(oauthStates.issued, s)
+		}
+	}
+	oauthStates.issued[state] = time.Now()
+	oauthStates.mu.Unlock()
+	http.SetCookie(res, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/oAuth",
+		MaxAge:   int(stateLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	q := url.Values{
+		"client_id":    {slackClientID},
+		"user_scope":   {"chat:write"},
+		"redirect_uri": {settings.SlackBotURL + "/oAuth"},
+		"state":        {state},
+	}
+	http.Redirect(res, req, "https://slack.com/oauth/v2/authorize?"+q.Encode(), http.StatusSeeOther)
+}
+
+// validState checks the state of an OAuth callback. Each state is good for
+// one callback.
+func validState(req *http.Request) bool {
+	state := req.URL.Query().Get("state")
+	cookie, err := req.Cookie(stateCookie)
+	if state == "" || err != nil || cookie.Value != state {
+		return false
+	}
+	oauthStates.mu.Lock()
+	defer oauthStates.mu.Unlock()
+	issued, ok := oauthStates.issued[state]
+	delete(oauthStates.issued, state)
+	return ok && time.Since(issued) <= stateLifetime
+}
@@ -0,0 +1,29 @@
+package slack
+
+import "context"
+
+// API is the part of the Slack Web API Choowie uses. Client implements it
+// against Slack; tests and other services can swap in their own.
+type API interface {
+	OauthV2Access(ctx context.Context, params OAuthAccessRequest) ([]User, error)
+	AuthTest(ctx context.Context, user bool) (AuthTestResponse, error)
+	PostMessage(ctx context.Context, msg PostMessageRequest, ephemeral bool) (string, error)
+	UpdateMessage(ctx context.Context, msg UpdateMessageRequest) error
+	DeleteMessage(ctx context.Context, channel string, ts string) error
+	DeleteBotMessage(ctx context.Context, channel string, ts string) error
+	GetUser(ctx context.Context, user string) (User, error)
+	Replies(ctx context.Context, params RepliesRequest) (MessagesResponse, error)
+	GetThreadLimit(ctx context.Context, limit int, channel string, thread_ts string) ([]Message, error)
+	RetrieveMessage(ctx context.Context, channel string, ts string) (Message, error)
+	GetPermalink(ctx context.Context, channel string, ts string) (string, error)
+	FileInfo(ctx context.Context, file_id string) (File, error)
+	GetUploadUrl(ctx context.Context, filename string, filesize int) (string, string, error)
+	ReloadFile(ctx context.Context, url_from string, url_to string, content_type string) error
+	CompleteUpload(ctx context.Context, to_channel string, comment string, thread_ts string, files []FileSummary) ([]File, error)
+	AttachFiles(ctx context.Context, channel string, ts string, message string, files []string) error
+	JoinChannel(ctx context.Context, channel string) error
+	OpenConnection(ctx context.Context) (string, error)
+	OpenView(ctx context.Context, trigger_id string, view View) error
+}
+
+var _ API = (*Client)(nil)
@@ -0,0 +1,465 @@
+// Package slack is the Slack Web API client of Choowie: typed requests,
+// rate limiting, thread pagination, file re-uploads and Socket Mode.
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const DefaultAPIURL = "https://slack.com/api/"
+
+// Client calls the Slack Web API. Tokens are read through the token
+// functions on every call. Empty fields fall back to slack.com, the default
+// HTTP client, no rate limiting and 30 second calls.
+type Client struct {
+	APIURL      string
+	FilesHost   string
+	HTTPClient  *http.Client
+	Limiter     *RateLimiter
+	CallTimeout time.Duration
+	FileTimeout time.Duration
+	BotToken    TokenFunc
+	UserToken   TokenFunc
+	AppToken    TokenFunc
+}
+
+// TokenFunc returns the token for a call. With token rotation it refreshes
+// the token first when it is about to expire.
+type TokenFunc func(ctx context.Context) (string, error)
+
+// StaticToken is a TokenFunc for a token that does not rotate.
+func StaticToken(token string) TokenFunc {
+	return func(ctx context.Context) (string, error) {
+		return token, nil
+	}
+}
+
+type request struct {
+	method      string
+	reqmethod   string
+	contentType string
+	token       TokenFunc
+}
+
+type apiResponse interface {
+	status() *Response
+}
+
+func (r *Response) status() *Response {
+	return r
+}
+
+func token(ctx context.Context, source TokenFunc) (string, error) {
+	if source == nil {
+		return "", nil
+	}
+	return source(ctx)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+func (c *Client) apiURL() string {
+	if c.APIURL == "" {
+		return DefaultAPIURL
+	}
+	return strings.TrimSuffix(c.APIURL, "/") + "/"
+}
+
+func (c *Client) timeout(d time.Duration, fallback time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// call sends params as JSON or form data, depending on the content type of
+// the request, and decodes the reply into result.
+func (c *Client) call(ctx context.Context, req request, params any, result apiResponse) error {
+	if req.method == "" {
+		return errors.New("API method not set")
+	}
+	if req.reqmethod == "" {
+		req.reqmethod = "POST"
+	}
+	if req.contentType == "" {
+		req.contentType = "application/x-www-form-urlencoded"
+	}
+	url := c.apiURL() + req.method
+	var body []byte
+	if req.contentType == "application/json" {
+		json_data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		body = json_data
+	} else {
+		values, err := formValues(params)
+		if err != nil {
+			return err
+		}
+		if req.reqmethod == "GET" {
+			url += "?" + values.Encode()
+		} else {
+			body = []byte(values.Encode())
+		}
+	}
+	return c.send(ctx, req, url, body, result)
+}
+
+func (c *Client) send(ctx context.Context, req request, url string, body []byte, result apiResponse) error {
+	for attempt := 0; ; attempt++ {
+		err := c.Limiter.Wait(ctx, req.method)
+		if err != nil {
+			return err
+		}
+		res, resbody, err := c.do(ctx, req, url, body)
+		if err != nil {
+			return err
+		}
+		reset(result)
+		response := result.status()
+		if res.StatusCode != http.StatusTooManyRequests {
+			err = json.Unmarshal(resbody, result)
+			if err != nil {
+				return err
+			}
+		}
+		if res.StatusCode == http.StatusTooManyRequests || response.Error == "ratelimited" {
+			wait := retryAfter(res)
+			c.Limiter.Block(req.method, wait)
+			if attempt < maxRateLimitRetries {
+				fmt.Fprintln(os.Stderr, "Rate limited on "+req.method+", retrying in "+wait.String())
+				if c.Limiter == nil {
+					if err := sleep(ctx, wait); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			return &Error{Method: req.method, Code: "ratelimited"}
+		}
+		if response.Ok == false {
+			return &Error{
+				Method:   req.method,
+				Code:     response.Error,
+				Needed:   response.Needed,
+				Provided: response.Provided,
+				Warning:  response.Warning,
+				Messages: response.Metadata.Messages,
+			}
+		}
+		return nil
+	}
+}
+
+// reset clears the reply of an earlier attempt, since json.Unmarshal keeps
+// the fields a reply leaves out, such as the error of a ratelimited one.
+func reset(result apiResponse) {
+	v := reflect.ValueOf(result).Elem()
+	v.Set(reflect.Zero(v.Type()))
+}
+
+func (c *Client) do(ctx context.Context, r request, url string, body []byte) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout(c.CallTimeout, 30*time.Second))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, r.reqmethod, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", r.contentType)
+	bearer, err := token(ctx, r.token)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot get token for %s: %w", r.method, err)
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	resbody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, resbody, nil
+}
+
+func (c *Client) OauthV2Access(ctx context.Context, params OAuthAccessRequest) ([]User, error) {
+	var result OAuthAccessResponse
+	err := c.call(ctx, request{method: "oauth.v2.access"}, params, &result)
+	if err != nil {
+		return []User{}, err
+	}
+	return result.RetrieveAuthedUsers(), nil
+
+}
+
+// AuthTest tells who the bot token, or the user token when user is set,
+// belongs to.
+func (c *Client) AuthTest(ctx context.Context, user bool) (AuthTestResponse, error) {
+	req := request{method: "auth.test", token: c.BotToken}
+	if user {
+		req.token = c.UserToken
+	}
+	var response AuthTestResponse
+	err := c.call(ctx, req, nil, &response)
+	return response, err
+}
+
+func (c *Client) PostMessage(ctx context.Context, msg PostMessageRequest, ephemeral bool) (string, error) {
+	req := request{method: "chat.postMessage", contentType: "application/json", token: c.BotToken}
+	if ephemeral == true {
+		req.method = "chat.postEphemeral"
+	}
+	var response PostMessageResponse
+	err := c.call(ctx, req, msg, &response)
+	if err != nil {
+		return "", err
+	}
+	if ephemeral == true {
+		return response.MessageTs, nil
+	}
+	return response.Timestamp, nil
+
+}
+
+func (c *Client) UpdateMessage(ctx context.Context, msg UpdateMessageRequest) error {
+	var response Response
+	return c.call(ctx, request{method: "chat.update", contentType: "application/json", token: c.BotToken}, msg, &response)
+}
+
+// DeleteMessage deletes with the user token, which can remove messages of
+// other people when it belongs to a workspace admin.
+func (c *Client) DeleteMessage(ctx context.Context, channel string, ts string) error {
+	var response Response
+	return c.call(ctx, request{method: "chat.delete", contentType: "application/json", token: c.UserToken}, DeleteMessageRequest{Channel: channel, Ts: ts, AsUser: true}, &response)
+}
+
+// DeleteBotMessage deletes a message the bot posted, with the bot token.
+func (c *Client) DeleteBotMessage(ctx context.Context, channel string, ts string) error {
+	var response Response
+	return c.call(ctx, request{method: "chat.delete", contentType: "application/json", token: c.BotToken}, DeleteMessageRequest{Channel: channel, Ts: ts}, &response)
+}
+
+func (c *Client) GetUser(ctx context.Context, user string) (User, error) {
+	var response UserInfoResponse
+	err := c.call(ctx, request{method: "users.info", reqmethod: "GET", token: c.BotToken}, UserInfoRequest{User: user}, &response)
+	if err != nil {
+		return User{}, err
+	}
+	return response.User, nil
+
+}
+
+func (c *Client) Replies(ctx context.Context, params RepliesRequest) (MessagesResponse, error) {
+	var response MessagesResponse
+	err := c.call(ctx, request{method: "conversations.replies", reqmethod: "GET", token: c.BotToken}, params, &response)
+	return response, err
+}
+
+func (c *Client) GetThreadLimit(ctx context.Context, limit int, channel string, thread_ts string) ([]Message, error) {
+	response, err := c.Replies(ctx, RepliesRequest{Channel: channel, Ts: thread_ts, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return response.Messages, nil
+}
+
+func (c *Client) RetrieveMessage(ctx context.Context, channel string, ts string) (Message, error) {
+	var response MessagesResponse
+	err := c.call(ctx, request{method: "conversations.history", reqmethod: "GET", token: c.BotToken}, HistoryRequest{Channel: channel, Latest: ts, Limit: 1, Inclusive: true}, &response)
+	if err != nil {
+		return Message{}, err
+	}
+	if len(response.Messages) == 0 {
+		return Message{}, errors.New("message_not_found")
+	}
+	return response.Messages[0], nil
+}
+
+// GetPermalink returns the link to a message in the Slack client.
+func (c *Client) GetPermalink(ctx context.Context, channel string, ts string) (string, error) {
+	var response PermalinkResponse
+	err := c.call(ctx, request{method: "chat.getPermalink", reqmethod: "GET", token: c.BotToken}, PermalinkRequest{Channel: channel, MessageTs: ts}, &response)
+	if err != nil {
+		return "", err
+	}
+	return response.Permalink, nil
+}
+
+func (c *Client) FileInfo(ctx context.Context, file_id string) (File, error) {
+	var response FileInfoResponse
+	err := c.call(ctx, request{method: "files.info", reqmethod: "GET", token: c.BotToken}, FileInfoRequest{File: file_id}, &response)
+	if err != nil {
+		return File{}, err
+	}
+	return response.File, nil
+}
+
+func (c *Client) GetUploadUrl(ctx context.Context, filename string, filesize int) (string, string, error) {
+	var response UploadURLResponse
+	err := c.call(ctx, request{method: "files.getUploadURLExternal", reqmethod: "GET", token: c.BotToken}, UploadURLRequest{Filename: filename, Length: filesize}, &response)
+	if err != nil {
+		return "", "", err
+	}
+	return response.UploadURL, response.FileId, nil
+}
+
+// CompleteUpload shares the uploaded files to a channel and returns them.
+func (c *Client) CompleteUpload(ctx context.Context, to_channel string, comment string, thread_ts string, files []FileSummary) ([]File, error) {
+	params := CompleteUploadRequest{
+		Files:          files,
+		ChannelId:      to_channel,
+		ThreadTs:       thread_ts,
+		InitialComment: comment,
+	}
+	var response CompleteUploadResponse
+	err := c.call(ctx, request{method: "files.completeUploadExternal", contentType: "application/json", token: c.BotToken}, params, &response)
+	return response.Files, err
+}
+
+func (c *Client) AttachFiles(ctx context.Context, channel string, ts string, message string, files []string) error {
+	return c.UpdateMessage(ctx, UpdateMessageRequest{
+		Channel: channel,
+		Ts:      ts,
+		Text:    message,
+		AsUser:  true,
+		FileIds: files,
+	})
+}
+
+func (c *Client) JoinChannel(ctx context.Context, channel string) error {
+	var response Response
+	return c.call(ctx, request{method: "conversations.join", token: c.BotToken}, ChannelRequest{Channel: channel}, &response)
+}
+
+// OpenConnection returns a Socket Mode WebSocket URL, using the app-level
+// token.
+func (c *Client) OpenConnection(ctx context.Context) (string, error) {
+	var response ConnectionsOpenResponse
+	err := c.call(ctx, request{method: "apps.connections.open", token: c.AppToken}, nil, &response)
+	if err != nil {
+		return "", err
+	}
+	return response.URL, nil
+}
+
+func (r OAuthAccessResponse) RetrieveAuthedUsers() []User {
+	var users []User
+	if len(r.AccessToken) > 0 {
+		users = append(users, User{Id: r.BotUserId, TeamId: r.Team.Id, AccessToken: r.AccessToken, TokenType: r.TokenType, RefreshToken: r.RefreshToken, ExpiresIn: r.ExpiresIn})
+	}
+	if len(r.AuthedUser.AccessToken) > 0 {
+		user := r.AuthedUser
+		user.TeamId = r.Team.Id
+		users = append(users, user)
+	}
+	return users
+}
+
+// ReloadFile downloads a file from Slack and streams it to an upload URL
+// returned by GetUploadUrl.
+func (c *Client) ReloadFile(ctx context.Context, url_from string, url_to string, content_type string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout(c.FileTimeout, 5*time.Minute))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", c.fileURL(url_from), nil)
+	if err != nil {
+		return err
+	}
+	bearer, err := token(ctx, c.BotToken)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+bearer)
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Cannot download the file: %s", res.Status)
+	}
+	req, err = http.NewRequestWithContext(ctx, "POST", url_to, res.Body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", content_type)
+	upload, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	upload.Body.Close()
+	if upload.StatusCode < 200 || upload.StatusCode > 299 {
+		return fmt.Errorf("Cannot upload the file: %s", upload.Status)
+	}
+	return nil
+}
+
+// fileURL points a url_private link at the configured files host, so
+// downloads go to the same place as API calls in staging and CI.
+func (c *Client) fileURL(u string) string {
+	if c.FilesHost == "" {
+		return u
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	host, err := url.Parse(c.FilesHost)
+	if err != nil {
+		return u
+	}
+	parsed.Scheme = host.Scheme
+	parsed.Host = host.Host
+	if p := strings.TrimSuffix(host.Path, "/"); p != "" {
+		parsed.Path = p + parsed.Path
+	}
+	return parsed.String()
+}
+
+// UploadFiles copies files into new uploads, ready to be shared with
+// CompleteUpload.
+func UploadFiles(ctx context.Context, api API, files []File) ([]FileSummary, error) {
+	var filelist []FileSummary
+	for _, file := range files {
+		url, file_id, err := api.GetUploadUrl(ctx, file.Name, file.Size)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot get upload url for %s (%d): %w", file.Name, file.Size, err)
+		}
+		filelist = append(filelist, FileSummary{Id: file_id, Title: file.Title})
+		err = api.ReloadFile(ctx, file.UrlPrivate, url, file.MimeType)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot reload file: %w", err)
+		}
+	}
+	return filelist, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,42 @@
+package slack
+
+import (
+	"errors"
+	"strings"
+)
+
+// Error is an ok:false reply of the Web API with the metadata Slack sends
+// along with the error code.
+type Error struct {
+	Method   string
+	Code     string
+	Needed   string
+	Provided string
+	Warning  string
+	Messages []string
+}
+
+func (e *Error) Error() string {
+	msg := e.Method + ": " + e.Code
+	if e.Needed != "" {
+		msg += " (needed: " + e.Needed + ", provided: " + e.Provided + ")"
+	}
+	if len(e.Messages) > 0 {
+		msg += " " + strings.Join(e.Messages, "; ")
+	}
+	return msg
+}
+
+// IsError reports whether err is a Slack error with one of the codes.
+func IsError(err error, codes ...string) bool {
+	var slackErr *Error
+	if !errors.As(err, &slackErr) {
+		return false
+	}
+	for _, code := range codes {
+		if slackErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package slack
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Requests per minute for the Slack methods the bot uses, following the
+// Web API rate tiers. Unknown methods fall back to tier 3.
+var rateTiers = map[string]int{
+	"oauth.v2.access":              100,
+	"chat.postMessage":             60,
+	"chat.postEphemeral":           100,
+	"chat.update":                  50,
+	"chat.delete":                  50,
+	"users.info":                   100,
+	"conversations.replies":        50,
+	"conversations.history":        50,
+	"files.info":                   100,
+	"files.getUploadURLExternal":   20,
+	"files.completeUploadExternal": 20,
+	"apps.connections.open":        20,
+	"conversations.join":           50,
+}
+
+const defaultRateTier = 50
+const maxRateLimitRetries = 5
+const defaultRetryAfter = 1 * time.Second
+
+type methodBudget struct {
+	interval time.Duration
+	next     time.Time
+}
+
+type RateLimiter struct {
+	budgets map[string]*methodBudget
+	mu      sync.Mutex
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		budgets: make(map[string]*methodBudget),
+	}
+}
+
+func (rl *RateLimiter) budget(method string) *methodBudget {
+	if b, ok := rl.budgets[method]; ok {
+		return b
+	}
+	rpm, ok := rateTiers[method]
+	if !ok {
+		rpm = defaultRateTier
+	}
+	b := &methodBudget{interval: time.Minute / time.Duration(rpm)}
+	rl.budgets[method] = b
+	return b
+}
+
+// Wait blocks until the method has a free slot in its budget or ctx is done.
+// Slots are handed out in call order, so concurrent callers are queued, not
+// rejected.
+func (rl *RateLimiter) Wait(ctx context.Context, method string) error {
+	if rl == nil {
+		return nil
+	}
+	rl.mu.Lock()
+	b := rl.budget(method)
+	now := time.Now()
+	slot := b.next
+	if slot.Before(now) {
+		slot = now
+	}
+	b.next = slot.Add(b.interval)
+	rl.mu.Unlock()
+	return sleep(ctx, time.Until(slot))
+}
+
+// Block pushes the next free slot of the method at least d into the future.
+func (rl *RateLimiter) Block(method string, d time.Duration) {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	b := rl.budget(method)
+	if until := time.Now().Add(d); b.next.Before(until) {
+		b.next = until
+	}
+}
+
+func retryAfter(res *http.Response) time.Duration {
+	if res == nil {
+		return defaultRetryAfter
+	}
+	sec, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || sec <= 0 {
+		return defaultRetryAfter
+	}
+	return time.Duration(sec) * time.Second
+}
@@ -0,0 +1,157 @@
+package slack
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aageorg/slackbot_prod/slacktest"
+)
+
+func withTier(t *testing.T, method string, rpm int) {
+	t.Helper()
+	rateTiers[method] = rpm
+	t.Cleanup(func() { delete(rateTiers, method) })
+}
+
+func TestRateLimiterSpacesCalls(t *testing.T) {
+	withTier(t, "test.spaced", 1200) // one call every 50ms
+	rl := NewRateLimiter()
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := rl.Wait(context.Background(), "test.spaced"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("4 calls took %s, want at least 150ms", elapsed)
+	}
+}
+
+func TestRateLimiterKeepsMethodsApart(t *testing.T) {
+	withTier(t, "test.slow", 1)
+	rl := NewRateLimiter()
+	ctx := context.Background()
+	if err := rl.Wait(ctx, "test.slow"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := rl.Wait(ctx, "chat.postMessage"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("chat.postMessage waited %s for test.slow", elapsed)
+	}
+}
+
+func TestRateLimiterBlock(t *testing.T) {
+	rl := NewRateLimiter()
+	rl.Block("chat.update", 200*time.Millisecond)
+	start := time.Now()
+	if err := rl.Wait(context.Background(), "chat.update"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Wait after Block took %s, want about 200ms", elapsed)
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	withTier(t, "test.slow", 1)
+	rl := NewRateLimiter()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := rl.Wait(ctx, "test.slow"); err != nil {
+		t.Fatal(err)
+	}
+	err := rl.Wait(ctx, "test.slow")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestNilRateLimiter(t *testing.T) {
+	var rl *RateLimiter
+	rl.Block("chat.postMessage", time.Hour)
+	if err := rl.Wait(context.Background(), "chat.postMessage"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"3", 3 * time.Second},
+		{"", defaultRetryAfter},
+		{"0", defaultRetryAfter},
+		{"soon", defaultRetryAfter},
+	}
+	for _, tt := range tests {
+		res := &http.Response{Header: http.Header{}}
+		res.Header.Set("Retry-After", tt.header)
+		if got := retryAfter(res); got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+	if got := retryAfter(nil); got != defaultRetryAfter {
+		t.Errorf("retryAfter(nil) = %s, want %s", got, defaultRetryAfter)
+	}
+}
+
+func TestClientRetriesAfterRateLimit(t *testing.T) {
+	fake := slacktest.NewServer()
+	defer fake.Close()
+	fake.RateLimit("chat.postMessage", 1)
+	client := &Client{APIURL: fake.APIURL(), Limiter: NewRateLimiter(), BotToken: StaticToken("xoxb-test")}
+	start := time.Now()
+	ts, err := client.PostMessage(context.Background(), PostMessageRequest{Channel: "C1", Text: "hello"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want the 1s of Retry-After", elapsed)
+	}
+	if calls := fake.Calls("chat.postMessage"); len(calls) != 2 {
+		t.Errorf("chat.postMessage called %d times, want 2", len(calls))
+	}
+	if messages := fake.Messages("C1"); len(messages) != 1 || messages[0].Ts != ts {
+		t.Errorf("messages in C1 = %+v, want the one posted", messages)
+	}
+}
+
+func TestClientRetriesAfterRatelimitedReply(t *testing.T) {
+	fake := slacktest.NewServer()
+	defer fake.Close()
+	fake.FailWith("chat.postMessage", "ratelimited", nil)
+	client := &Client{APIURL: fake.APIURL(), Limiter: NewRateLimiter(), BotToken: StaticToken("xoxb-test")}
+	ts, err := client.PostMessage(context.Background(), PostMessageRequest{Channel: "C1", Text: "hello"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := fake.Calls("chat.postMessage"); len(calls) != 2 {
+		t.Errorf("chat.postMessage called %d times, want 2", len(calls))
+	}
+	if messages := fake.Messages("C1"); len(messages) != 1 || messages[0].Ts != ts {
+		t.Errorf("messages in C1 = %+v, want the one posted", messages)
+	}
+}
+
+func TestClientStopsRetryingWhenCancelled(t *testing.T) {
+	fake := slacktest.NewServer()
+	defer fake.Close()
+	fake.RateLimit("users.info", 30)
+	client := &Client{APIURL: fake.APIURL(), Limiter: NewRateLimiter(), BotToken: StaticToken("xoxb-test")}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := client.GetUser(ctx, "U1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetUser = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if calls := fake.Calls("users.info"); len(calls) != 1 {
+		t.Errorf("users.info called %d times, want 1", len(calls))
+	}
+}
@@ -0,0 +1,200 @@
+package slack
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type OAuthAccessRequest struct {
+	Code         string `json:"code,omitempty"`
+	GrantType    string `json:"grant_type,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+type PostMessageRequest struct {
+	Channel     string       `json:"channel"`
+	User        string       `json:"user,omitempty"`
+	Text        string       `json:"text,omitempty"`
+	ThreadTs    string       `json:"thread_ts,omitempty"`
+	Broadcast   bool         `json:"reply_broadcast,omitempty"`
+	Username    string       `json:"username,omitempty"`
+	IconUrl     string       `json:"icon_url,omitempty"`
+	Blocks      []Block      `json:"blocks,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+type UpdateMessageRequest struct {
+	Channel     string       `json:"channel"`
+	Ts          string       `json:"ts"`
+	Text        string       `json:"text,omitempty"`
+	AsUser      bool         `json:"as_user,omitempty"`
+	FileIds     []string     `json:"file_ids,omitempty"`
+	Blocks      []Block      `json:"blocks,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+type DeleteMessageRequest struct {
+	Channel string `json:"channel"`
+	Ts      string `json:"ts"`
+	AsUser  bool   `json:"as_user,omitempty"`
+}
+
+type UserInfoRequest struct {
+	User string `json:"user"`
+}
+
+type RepliesRequest struct {
+	Channel string `json:"channel"`
+	Ts      string `json:"ts"`
+	Oldest  string `json:"oldest,omitempty"`
+	Cursor  string `json:"cursor,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+type HistoryRequest struct {
+	Channel   string `json:"channel"`
+	Latest    string `json:"latest,omitempty"`
+	Oldest    string `json:"oldest,omitempty"`
+	Inclusive bool   `json:"inclusive,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+}
+
+type PermalinkRequest struct {
+	Channel   string `json:"channel"`
+	MessageTs string `json:"message_ts"`
+}
+
+type ChannelRequest struct {
+	Channel string `json:"channel"`
+}
+
+type FileInfoRequest struct {
+	File string `json:"file"`
+}
+
+type UploadURLRequest struct {
+	Filename string `json:"filename"`
+	Length   int    `json:"length"`
+}
+
+type FileSummary struct {
+	Id    string `json:"id"`
+	Title string `json:"title,omitempty"`
+}
+
+type CompleteUploadRequest struct {
+	Files          []FileSummary `json:"files"`
+	ChannelId      string        `json:"channel_id,omitempty"`
+	ThreadTs       string        `json:"thread_ts,omitempty"`
+	InitialComment string        `json:"initial_comment,omitempty"`
+}
+
+type OAuthAccessResponse struct {
+	Response
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope"`
+	BotUserId    string `json:"bot_user_id"`
+	AppId        string `json:"app_id"`
+	Team         Team   `json:"team"`
+	AuthedUser   User   `json:"authed_user"`
+}
+
+type PostMessageResponse struct {
+	Response
+	Channel   string `json:"channel"`
+	Timestamp string `json:"ts"`
+	MessageTs string `json:"message_ts"`
+}
+
+type UserInfoResponse struct {
+	Response
+	User User `json:"user"`
+}
+
+type MessagesResponse struct {
+	Response
+	Messages []Message `json:"messages"`
+	HasMore  bool      `json:"has_more"`
+}
+
+type FileInfoResponse struct {
+	Response
+	File File `json:"file"`
+}
+
+type UploadURLResponse struct {
+	Response
+	UploadURL string `json:"upload_url"`
+	FileId    string `json:"file_id"`
+}
+
+type CompleteUploadResponse struct {
+	Response
+	Files []File `json:"files"`
+}
+
+type PermalinkResponse struct {
+	Response
+	Channel   string `json:"channel"`
+	Permalink string `json:"permalink"`
+}
+
+type AuthTestResponse struct {
+	Response
+	UserId string `json:"user_id"`
+	TeamId string `json:"team_id"`
+	BotId  string `json:"bot_id"`
+}
+
+type ConnectionsOpenResponse struct {
+	Response
+	URL string `json:"url"`
+}
+
+// formValues encodes a request struct for GET and form-encoded POST calls,
+// using the JSON field names. Nested values are sent as JSON strings, the
+// way Slack expects blocks and attachments in form data.
+func formValues(params any) (url.Values, error) {
+	values := url.Values{}
+	if params == nil {
+		return values, nil
+	}
+	v := reflect.ValueOf(params)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")
+		if tag[0] == "" || tag[0] == "-" {
+			continue
+		}
+		f := v.Field(i)
+		if len(tag) > 1 && tag[1] == "omitempty" && f.IsZero() {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.String:
+			values.Set(tag[0], f.String())
+		case reflect.Bool:
+			values.Set(tag[0], strconv.FormatBool(f.Bool()))
+		case reflect.Int, reflect.Int64:
+			values.Set(tag[0], strconv.FormatInt(f.Int(), 10))
+		default:
+			b, err := json.Marshal(f.Interface())
+			if err != nil {
+				return nil, err
+			}
+			values.Set(tag[0], string(b))
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,175 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Envelope struct {
+	EnvelopeId             string          `json:"envelope_id"`
+	Type                   string          `json:"type"`
+	Reason                 string          `json:"reason,omitempty"`
+	AcceptsResponsePayload bool            `json:"accepts_response_payload"`
+	RetryAttempt           int             `json:"retry_attempt"`
+	RetryReason            string          `json:"retry_reason"`
+	Payload                json.RawMessage `json:"payload"`
+}
+
+const maxReconnectDelay = 30 * time.Second
+
+// SocketMode receives events, slash commands and interactive payloads over
+// a WebSocket instead of public HTTP endpoints. Events and commands are
+// acked before their handler runs in its own goroutine.
+type SocketMode struct {
+	API           API
+	Transport     http.RoundTripper
+	OnEvent       func(ctx context.Context, callback Callback)
+	OnCommand     func(ctx context.Context, command url.Values) error
+	OnInteractive func(ctx context.Context, payload json.RawMessage) any
+}
+
+type ackMessage struct {
+	EnvelopeId string `json:"envelope_id"`
+	Payload    any    `json:"payload,omitempty"`
+}
+
+// Run keeps a Socket Mode connection open until ctx is done, reconnecting
+// with a growing delay when Slack or the network drops it.
+func (sm *SocketMode) Run(ctx context.Context) {
+	delay := time.Second
+	for ctx.Err() == nil {
+		err := sm.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			delay = time.Second
+			continue
+		}
+		fmt.Fprintln(os.Stderr, "Socket Mode connection lost: "+err.Error()+". Reconnecting in "+delay.String())
+		if sleep(ctx, delay) != nil {
+			return
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// session serves one WebSocket connection. It returns nil when Slack asks
+// for a reconnect.
+func (sm *SocketMode) session(ctx context.Context) error {
+	wsurl, err := sm.API.OpenConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("Cannot open connection: %w", err)
+	}
+	conn, err := dialWebSocket(ctx, sm.Transport, wsurl)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+			conn.Close()
+		}
+	}()
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var envelope Envelope
+		err = json.Unmarshal(data, &envelope)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot parse Socket Mode envelope: "+err.Error())
+			continue
+		}
+		// Interactive payloads are answered in the ack, so their handler
+		// runs first. It has to be quick.
+		var response any
+		if envelope.Type == "interactive" && sm.OnInteractive != nil {
+			response = sm.OnInteractive(ctx, envelope.Payload)
+		}
+		if envelope.EnvelopeId != "" {
+			ack, _ := json.Marshal(ackMessage{EnvelopeId: envelope.EnvelopeId, Payload: response})
+			err = conn.WriteMessage(ack)
+			if err != nil {
+				return err
+			}
+		}
+		switch envelope.Type {
+		case "hello":
+			fmt.Fprintln(os.Stderr, "Socket Mode connected")
+		case "disconnect":
+			fmt.Fprintln(os.Stderr, "Socket Mode disconnect requested: "+envelope.Reason)
+			return nil
+		case "events_api":
+			if envelope.RetryAttempt > 0 {
+				fmt.Fprintln(os.Stderr, "Socket Mode event redelivered, attempt "+strconv.Itoa(envelope.RetryAttempt)+": "+envelope.RetryReason)
+			}
+			var callback Callback
+			err = json.Unmarshal(envelope.Payload, &callback)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				continue
+			}
+			if sm.OnEvent != nil {
+				go sm.OnEvent(ctx, callback)
+			}
+		case "slash_commands":
+			q, err := commandValues(envelope.Payload)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				continue
+			}
+			if sm.OnCommand == nil {
+				continue
+			}
+			go func() {
+				err := sm.OnCommand(ctx, q)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "Error on slash command "+q.Get("command")+": "+err.Error())
+				}
+			}()
+		case "interactive":
+			if sm.OnInteractive == nil {
+				fmt.Fprintln(os.Stderr, "Interactive payload received, no interactive features are configured")
+			}
+		default:
+			fmt.Fprintln(os.Stderr, "Unknown Socket Mode envelope type: "+envelope.Type)
+		}
+	}
+}
+
+// commandValues turns a slash command payload into the form values Slack
+// posts to a slash command URL.
+func commandValues(payload json.RawMessage) (url.Values, error) {
+	var command map[string]any
+	err := json.Unmarshal(payload, &command)
+	if err != nil {
+		return nil, err
+	}
+	if command == nil {
+		return nil, errors.New("Empty slash command payload")
+	}
+	q := url.Values{}
+	for k, v := range command {
+		if s, ok := v.(string); ok {
+			q.Set(k, s)
+		}
+	}
+	return q, nil
+}
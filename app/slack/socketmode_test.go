@@ -0,0 +1,103 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aageorg/slackbot_prod/slacktest"
+)
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func acked(fake *slacktest.Server, id string) (slacktest.Ack, bool) {
+	for _, ack := range fake.Acks() {
+		if ack.EnvelopeId == id {
+			return ack, true
+		}
+	}
+	return slacktest.Ack{}, false
+}
+
+// receive sends a reaction in an events_api envelope and waits until the
+// handler gets it and the envelope is acked.
+func receive(t *testing.T, fake *slacktest.Server, events chan Callback, reaction string) {
+	t.Helper()
+	id, err := fake.SendEnvelope("events_api", map[string]any{
+		"type":  "event_callback",
+		"event": map[string]any{"type": "reaction_added", "reaction": reaction},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case callback := <-events:
+		if callback.Event.Reaction != reaction {
+			t.Errorf("Event with %q, want %q", callback.Event.Reaction, reaction)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the %s event", reaction)
+	}
+	waitFor(t, "the ack of "+id, func() bool {
+		_, ok := acked(fake, id)
+		return ok
+	})
+}
+
+func TestSocketModeRun(t *testing.T) {
+	fake := slacktest.NewServer()
+	defer fake.Close()
+	events := make(chan Callback)
+	sm := &SocketMode{
+		API: &Client{APIURL: fake.APIURL(), AppToken: StaticToken("xapp-test")},
+		OnEvent: func(ctx context.Context, callback Callback) {
+			events <- callback
+		},
+		OnInteractive: func(ctx context.Context, payload json.RawMessage) any {
+			return map[string]any{"response_action": "clear"}
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		sm.Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+	waitFor(t, "the connection", func() bool { return fake.Connections() == 1 })
+
+	receive(t, fake, events, "eyes")
+
+	id, err := fake.SendEnvelope("interactive", map[string]any{"type": "view_submission"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the ack of the interactive envelope", func() bool {
+		_, ok := acked(fake, id)
+		return ok
+	})
+	ack, _ := acked(fake, id)
+	if string(ack.Payload) != `{"response_action":"clear"}` {
+		t.Errorf("Ack payload = %s, want the response of the handler", ack.Payload)
+	}
+
+	fake.Disconnect("refresh_requested")
+	waitFor(t, "the reconnect", func() bool { return fake.Connections() == 1 })
+	if calls := fake.Calls("apps.connections.open"); len(calls) != 2 {
+		t.Errorf("apps.connections.open called %d times, want 2", len(calls))
+	}
+	receive(t, fake, events, "tada")
+}
@@ -0,0 +1,78 @@
+package slack
+
+import (
+	"context"
+	"errors"
+)
+
+type ThreadIterator struct {
+	api     API
+	params  RepliesRequest
+	page    []Message
+	current Message
+	rootTs  string
+	started bool
+	err     error
+}
+
+func NewThreadIterator(api API, channel string, ts string, pageSize int) *ThreadIterator {
+	return &ThreadIterator{
+		api:    api,
+		params: RepliesRequest{Channel: channel, Ts: ts, Limit: pageSize},
+	}
+}
+
+func (it *ThreadIterator) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		if it.err != nil || (it.started && it.params.Cursor == "") {
+			return false
+		}
+		if err := it.fetch(ctx); err != nil {
+			it.err = err
+			return false
+		}
+	}
+	it.current = it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// Open loads the first page, so errors about the thread itself, such as
+// not_in_channel, surface before any message is handled.
+func (it *ThreadIterator) Open(ctx context.Context) error {
+	if it.started || it.err != nil {
+		return it.err
+	}
+	it.err = it.fetch(ctx)
+	return it.err
+}
+
+func (it *ThreadIterator) fetch(ctx context.Context) error {
+	response, err := it.api.Replies(ctx, it.params)
+	if err != nil {
+		return err
+	}
+	if !it.started && len(response.Messages) == 0 {
+		return errors.New("thread_not_found")
+	}
+	for _, m := range response.Messages {
+		if it.started && m.Ts == it.rootTs {
+			continue
+		}
+		if !it.started {
+			it.rootTs = m.Ts
+			it.started = true
+		}
+		it.page = append(it.page, m)
+	}
+	it.params.Cursor = response.Metadata.NextCursor
+	return nil
+}
+
+func (it *ThreadIterator) Message() Message {
+	return it.current
+}
+
+func (it *ThreadIterator) Err() error {
+	return it.err
+}
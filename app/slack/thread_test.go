@@ -0,0 +1,101 @@
+package slack
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aageorg/slackbot_prod/slacktest"
+)
+
+func newThread(t *testing.T, replies int) (*slacktest.Server, *Client, string) {
+	t.Helper()
+	fake := slacktest.NewServer()
+	t.Cleanup(fake.Close)
+	root := fake.AddMessage("C1", slacktest.Message{User: "U1", Text: "root"})
+	for i := 1; i <= replies; i++ {
+		fake.AddMessage("C1", slacktest.Message{User: "U1", ThreadTs: root, Text: fmt.Sprintf("reply %d", i)})
+	}
+	return fake, &Client{APIURL: fake.APIURL(), BotToken: StaticToken("xoxb-test")}, root
+}
+
+func collect(t *testing.T, it *ThreadIterator) []string {
+	t.Helper()
+	var texts []string
+	for it.Next(context.Background()) {
+		texts = append(texts, it.Message().Text)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return texts
+}
+
+func TestThreadIteratorPages(t *testing.T) {
+	fake, client, root := newThread(t, 7)
+	texts := collect(t, NewThreadIterator(client, "C1", root, 3))
+	want := []string{"root", "reply 1", "reply 2", "reply 3", "reply 4", "reply 5", "reply 6", "reply 7"}
+	if fmt.Sprint(texts) != fmt.Sprint(want) {
+		t.Errorf("messages = %q, want %q", texts, want)
+	}
+	// Every page repeats the root, which is returned only once.
+	if calls := fake.Calls("conversations.replies"); len(calls) != 3 {
+		t.Errorf("conversations.replies called %d times, want 3", len(calls))
+	}
+}
+
+func TestThreadIteratorFollowsCursor(t *testing.T) {
+	fake, client, root := newThread(t, 4)
+	collect(t, NewThreadIterator(client, "C1", root, 2))
+	var cursors []string
+	for _, call := range fake.Calls("conversations.replies") {
+		cursor, _ := call.Params["cursor"].(string)
+		cursors = append(cursors, cursor)
+	}
+	if fmt.Sprint(cursors) != fmt.Sprint([]string{"", "2"}) {
+		t.Errorf("cursors = %q, want the next_cursor of each page", cursors)
+	}
+}
+
+func TestThreadIteratorWithoutReplies(t *testing.T) {
+	_, client, root := newThread(t, 0)
+	texts := collect(t, NewThreadIterator(client, "C1", root, 100))
+	if len(texts) != 1 || texts[0] != "root" {
+		t.Errorf("messages = %q, want the root only", texts)
+	}
+}
+
+func TestThreadIteratorOpen(t *testing.T) {
+	_, client, _ := newThread(t, 0)
+	it := NewThreadIterator(client, "C1", "1.000000", 100)
+	err := it.Open(context.Background())
+	if !IsError(err, "thread_not_found") {
+		t.Fatalf("Open = %v, want thread_not_found", err)
+	}
+	if it.Next(context.Background()) {
+		t.Error("Next returned a message of a missing thread")
+	}
+	if it.Err() != err {
+		t.Errorf("Err = %v, want %v", it.Err(), err)
+	}
+}
+
+func TestThreadIteratorStopsOnError(t *testing.T) {
+	fake, client, root := newThread(t, 4)
+	it := NewThreadIterator(client, "C1", root, 2)
+	ctx := context.Background()
+	if err := it.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fake.Fail("conversations.replies", "internal_error")
+	var texts []string
+	for it.Next(ctx) {
+		texts = append(texts, it.Message().Text)
+	}
+	if len(texts) != 3 {
+		t.Errorf("got %d messages before the failing page, want 3", len(texts))
+	}
+	if !IsError(it.Err(), "internal_error") {
+		t.Errorf("Err = %v, want internal_error", it.Err())
+	}
+}
@@ -0,0 +1,241 @@
+package slack
+
+import "encoding/json"
+
+type Item struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	Ts      string `json:"ts"`
+}
+
+type Event struct {
+	Type            string   `json:"type"`
+	Subtype         string   `json:"subtype,omitempty"`
+	Reaction        string   `json:"reaction"`
+	EventTs         string   `json:"event_ts"`
+	User            string   `json:"user"`
+	ItemUser        string   `json:"item_user"`
+	Ts              string   `json:"ts"`
+	ThreadTs        string   `json:"thread_ts,omitempty"`
+	Text            string   `json:"text,omitempty"`
+	Item            Item     `json:"item"`
+	Channel         string   `json:"channel,omitempty"`
+	ChannelName     string   `json:"-"`
+	Message         *Message `json:"message,omitempty"`
+	PreviousMessage *Message `json:"previous_message,omitempty"`
+	DeletedTs       string   `json:"deleted_ts,omitempty"`
+	Tokens          *Tokens  `json:"tokens,omitempty"`
+}
+
+// UnmarshalJSON also accepts the channel of channel_rename and
+// group_rename, which is an object with the ID and the new name.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type event Event
+	var raw struct {
+		event
+		Channel json.RawMessage `json:"channel,omitempty"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	*e = Event(raw.event)
+	if len(raw.Channel) == 0 {
+		return nil
+	}
+	if raw.Channel[0] != '{' {
+		return json.Unmarshal(raw.Channel, &e.Channel)
+	}
+	var channel Conversation
+	err = json.Unmarshal(raw.Channel, &channel)
+	if err != nil {
+		return err
+	}
+	e.Channel = channel.Id
+	e.ChannelName = channel.Name
+	return nil
+}
+
+// Tokens lists the users whose tokens a tokens_revoked event revoked.
+type Tokens struct {
+	OAuth []string `json:"oauth"`
+	Bot   []string `json:"bot"`
+}
+
+type Team struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type Callback struct {
+	Token          string          `json:"token"`
+	TeamId         string          `json:"team_id"`
+	ApiAppId       string          `json:"api_app_id"`
+	Event          Event           `json:"event"`
+	Type           string          `json:"type"`
+	EventContext   string          `json:"event_context"`
+	EventId        string          `json:"event_id"`
+	EventTime      int64           `json:"event_time"`
+	Challenge      string          `json:"challenge"`
+	Authorizations []Authorization `json:"authorizations,omitempty"`
+}
+
+// Authorization is an installation the event is visible to. The one with
+// IsBot set names the user id of the bot.
+type Authorization struct {
+	TeamId string `json:"team_id"`
+	UserId string `json:"user_id"`
+	IsBot  bool   `json:"is_bot"`
+}
+
+// BotUserId returns the user id of the bot the event was sent to, or "".
+func (c Callback) BotUserId() string {
+	for _, a := range c.Authorizations {
+		if a.IsBot {
+			return a.UserId
+		}
+	}
+	return ""
+}
+
+type Profile struct {
+	ApiAppId string `json:"api_app_id,omitempty"`
+	Image72  string `json:"image_72,omitempty"`
+}
+
+type User struct {
+	Id           string  `json:"id"`
+	TeamId       string  `json:"team_id"`
+	UserName     string  `json:"name,omitempty"`
+	RealName     string  `json:"real_name"`
+	Profile      Profile `json:"profile"`
+	Scope        string  `json:"scope"`
+	AccessToken  string  `json:"access_token"`
+	TokenType    string  `json:"token_type"`
+	RefreshToken string  `json:"refresh_token,omitempty"`
+	ExpiresIn    int     `json:"expires_in,omitempty"`
+	IsAdmin      bool    `json:"is_admin"`
+	IsOwner      bool    `json:"is_owner"`
+	IsBot        bool    `json:"is_bot"`
+}
+
+type Metadata struct {
+	NextCursor string   `json:"next_cursor"`
+	Messages   []string `json:"messages,omitempty"`
+}
+
+type Element struct {
+	Type     string    `json:"type,omitempty"`
+	Text     string    `json:"text,omitempty"`
+	Emoji    bool      `json:"emoji,omitempty"`
+	ImageUrl string    `json:"image_url,omitempty"`
+	AltText  string    `json:"alt_text,omitempty"`
+	Elements []Element `json:"elements,omitempty"`
+}
+
+type Block struct {
+	Type     string        `json:"type"`
+	BlockId  string        `json:"block_id,omitempty"`
+	ImageUrl string        `json:"image_url,omitempty"`
+	AltText  string        `json:"alt_text,omitempty"`
+	Text     *Element      `json:"text,omitempty"`
+	Fields   []Element     `json:"fields,omitempty"`
+	Elements []Element     `json:"elements,omitempty"`
+	Label    *Element      `json:"label,omitempty"`
+	Element  *InputElement `json:"element,omitempty"`
+	Optional bool          `json:"optional,omitempty"`
+}
+
+type File struct {
+	Id                 string `json:"id"`
+	Name               string `json:"name"`
+	Title              string `json:"title"`
+	Mode               string `json:"mode"`
+	FileAccess         string `json:"file_access"`
+	UrlPrivate         string `json:"url_private"`
+	UrlPrivateDownload string `json:"url_private_download"`
+	Permalink          string `json:"permalink"`
+	PermalinkPublic    string `json:"permalink_public"`
+	MimeType           string `json:"mimetype"`
+	Size               int    `json:"size,omitempty"`
+	Shares             Shares `json:"shares,omitempty"`
+}
+
+// Shares lists the messages a file was shared in, by channel. Slack may
+// fill it in only after the upload was completed.
+type Shares struct {
+	Public  map[string][]Share `json:"public,omitempty"`
+	Private map[string][]Share `json:"private,omitempty"`
+}
+
+type Share struct {
+	Ts       string `json:"ts"`
+	ThreadTs string `json:"thread_ts,omitempty"`
+}
+
+// SharedTs is the ts of the message the file was shared in to the channel,
+// or "" when that is not known yet.
+func (f File) SharedTs(channel string) string {
+	for _, shares := range []map[string][]Share{f.Shares.Public, f.Shares.Private} {
+		if s := shares[channel]; len(s) > 0 {
+			return s[len(s)-1].Ts
+		}
+	}
+	return ""
+}
+
+// Icons is the icon a bot message was posted with.
+type Icons struct {
+	Image48 string `json:"image_48,omitempty"`
+	Image72 string `json:"image_72,omitempty"`
+}
+
+type Reaction struct {
+	Name  string   `json:"name"`
+	Users []string `json:"users"`
+	Count int      `json:"count"`
+}
+
+type Attachment struct {
+	Fallback      string        `json:"fallback,omitempty"`
+	Color         string        `json:"color,omitempty"`
+	Ptetext       string        `json:"pretext,omitempty"`
+	AuthorName    string        `json:"author_name,omitempty"`
+	AuthorLink    string        `json:"author_link,omitempty"`
+	AuthorIcon    string        `json:"author_icon,omitempty"`
+	Title         string        `json:"title,omitempty"`
+	TitleLink     string        `json:"title_link,omitempty"`
+	Text          string        `json:"text,omitempty"`
+	Fields        []interface{} `json:"fields,omitempty"`
+	ImageUrl      string        `json:"image_url,omitempty"`
+	ThumbUrl      string        `json:"thumb_url,omitempty"`
+	Footer        string        `json:"footer,omitempty"`
+	FooterIcon    string        `json:"footer_icon,omitempty"`
+	Ts            interface{}   `json:"ts,omitempty"`
+	Files         []File        `json:"files,omitempty"`
+	MessageBlocks []Block       `json:"message_blocks,omitempty"`
+}
+
+type Message struct {
+	Ts          string       `json:"ts"`
+	ThreadTs    string       `json:"thread_ts"`
+	User        string       `json:"user"`
+	BotId       string       `json:"bot_id,omitempty"`
+	Username    string       `json:"username,omitempty"`
+	Icons       *Icons       `json:"icons,omitempty"`
+	Text        string       `json:"text"`
+	Blocks      []Block      `json:"blocks,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Files       []File       `json:"files,omitempty"`
+	Reactions   []Reaction   `json:"reactions"`
+}
+
+// Response is the envelope every Web API reply shares.
+type Response struct {
+	Ok       bool     `json:"ok"`
+	Error    string   `json:"error"`
+	Warning  string   `json:"warning,omitempty"`
+	Needed   string   `json:"needed,omitempty"`
+	Provided string   `json:"provided,omitempty"`
+	Metadata Metadata `json:"response_metadata"`
+}
@@ -0,0 +1,90 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// InputElement is an interactive element of an input block, such as a
+// conversations_select, radio_buttons or plain_text_input.
+type InputElement struct {
+	Type                string              `json:"type"`
+	ActionId            string              `json:"action_id,omitempty"`
+	Placeholder         *Element            `json:"placeholder,omitempty"`
+	Options             []Option            `json:"options,omitempty"`
+	InitialOption       *Option             `json:"initial_option,omitempty"`
+	InitialConversation string              `json:"initial_conversation,omitempty"`
+	Multiline           bool                `json:"multiline,omitempty"`
+	Filter              *ConversationFilter `json:"filter,omitempty"`
+}
+
+type Option struct {
+	Text  *Element `json:"text"`
+	Value string   `json:"value"`
+}
+
+type ConversationFilter struct {
+	Include         []string `json:"include,omitempty"`
+	ExcludeBotUsers bool     `json:"exclude_bot_users,omitempty"`
+}
+
+type View struct {
+	Type            string   `json:"type"`
+	CallbackId      string   `json:"callback_id,omitempty"`
+	PrivateMetadata string   `json:"private_metadata,omitempty"`
+	Title           *Element `json:"title,omitempty"`
+	Submit          *Element `json:"submit,omitempty"`
+	Close           *Element `json:"close,omitempty"`
+	Blocks          []Block  `json:"blocks"`
+}
+
+type ViewsOpenRequest struct {
+	TriggerId string `json:"trigger_id"`
+	View      View   `json:"view"`
+}
+
+// ActionValue is the value of one input in the state of a submitted view.
+type ActionValue struct {
+	Type                 string  `json:"type"`
+	Value                string  `json:"value,omitempty"`
+	SelectedConversation string  `json:"selected_conversation,omitempty"`
+	SelectedOption       *Option `json:"selected_option,omitempty"`
+}
+
+type Conversation struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ViewState struct {
+	Id              string `json:"id"`
+	CallbackId      string `json:"callback_id"`
+	PrivateMetadata string `json:"private_metadata"`
+	State           struct {
+		Values map[string]map[string]ActionValue `json:"values"`
+	} `json:"state"`
+}
+
+// Interaction is the payload of a shortcut, block action or view
+// submission.
+type Interaction struct {
+	Type       string            `json:"type"`
+	CallbackId string            `json:"callback_id"`
+	TriggerId  string            `json:"trigger_id"`
+	User       User              `json:"user"`
+	Team       Team              `json:"team"`
+	Channel    Conversation      `json:"channel"`
+	Message    Message           `json:"message"`
+	View       ViewState         `json:"view"`
+	Actions    []json.RawMessage `json:"actions,omitempty"`
+}
+
+// Value returns the input of a submitted view by block and action id.
+func (v ViewState) Value(block_id string, action_id string) ActionValue {
+	return v.State.Values[block_id][action_id]
+}
+
+func (c *Client) OpenView(ctx context.Context, trigger_id string, view View) error {
+	var response Response
+	return c.call(ctx, request{method: "views.open", contentType: "application/json", token: c.BotToken}, ViewsOpenRequest{TriggerId: trigger_id, View: view}, &response)
+}
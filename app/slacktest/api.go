@@ -0,0 +1,304 @@
+package slacktest
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+func (s *Server) conversationsReplies(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel := str(params, "channel")
+	if _, ok := s.messages[channel]; !ok {
+		return nil, "channel_not_found"
+	}
+	thread := s.thread(channel, str(params, "ts"))
+	if len(thread) == 0 || thread[0].Ts != str(params, "ts") {
+		return nil, "thread_not_found"
+	}
+	sortByTs(thread)
+	limit, _ := strconv.Atoi(str(params, "limit"))
+	if limit <= 0 {
+		limit = 1000
+	}
+	offset, _ := strconv.Atoi(str(params, "cursor"))
+	replies := thread[1:]
+	if oldest, _ := strconv.ParseFloat(str(params, "oldest"), 64); oldest > 0 {
+		var newer []*Message
+		for _, m := range replies {
+			if ts, _ := strconv.ParseFloat(m.Ts, 64); ts > oldest {
+				newer = append(newer, m)
+			}
+		}
+		replies = newer
+	}
+	if offset > len(replies) {
+		offset = len(replies)
+	}
+	end := offset + limit
+	if end > len(replies) {
+		end = len(replies)
+	}
+	page := []Message{*thread[0]}
+	for _, m := range replies[offset:end] {
+		page = append(page, *m)
+	}
+	result := map[string]any{"messages": page, "has_more": end < len(replies)}
+	if end < len(replies) {
+		result["response_metadata"] = map[string]string{"next_cursor": strconv.Itoa(end)}
+	}
+	return result, ""
+}
+
+func (s *Server) conversationsHistory(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel := str(params, "channel")
+	if _, ok := s.messages[channel]; !ok {
+		return nil, "channel_not_found"
+	}
+	latest, _ := strconv.ParseFloat(str(params, "latest"), 64)
+	oldest, _ := strconv.ParseFloat(str(params, "oldest"), 64)
+	inclusive := str(params, "inclusive") == "true" || str(params, "inclusive") == "1"
+	limit, _ := strconv.Atoi(str(params, "limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	var top []*Message
+	for _, m := range s.messages[channel] {
+		if m.ThreadTs != "" && m.ThreadTs != m.Ts {
+			continue
+		}
+		ts, _ := strconv.ParseFloat(m.Ts, 64)
+		if latest > 0 && (ts > latest || (ts == latest && !inclusive)) {
+			continue
+		}
+		if oldest > 0 && (ts < oldest || (ts == oldest && !inclusive)) {
+			continue
+		}
+		top = append(top, m)
+	}
+	sortByTs(top)
+	var page []Message
+	for i := len(top) - 1; i >= 0 && len(page) < limit; i-- {
+		page = append(page, *top[i])
+	}
+	return map[string]any{"messages": page, "has_more": len(page) < len(top)}, ""
+}
+
+func (s *Server) conversationsJoin(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel := str(params, "channel")
+	if channel == "" {
+		return nil, "channel_not_found"
+	}
+	if _, ok := s.messages[channel]; !ok {
+		s.messages[channel] = nil
+	}
+	return map[string]any{"channel": map[string]string{"id": channel}}, ""
+}
+
+func (s *Server) authTest(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.tokens[str(params, "token")]
+	if !ok {
+		return nil, "invalid_auth"
+	}
+	return map[string]any{"user_id": user, "team_id": s.users[user].TeamId}, ""
+}
+
+func (s *Server) chatPostMessage(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel := str(params, "channel")
+	if channel == "" {
+		return nil, "channel_not_found"
+	}
+	m := Message{
+		ThreadTs:    str(params, "thread_ts"),
+		BotId:       s.BotId,
+		Username:    str(params, "username"),
+		IconUrl:     str(params, "icon_url"),
+		Text:        str(params, "text"),
+		Blocks:      raw(params, "blocks"),
+		Attachments: raw(params, "attachments"),
+	}
+	if m.IconUrl != "" {
+		m.Icons = map[string]string{"image_48": m.IconUrl}
+	}
+	if m.Text == "" && m.Blocks == nil && m.Attachments == nil {
+		return nil, "no_text"
+	}
+	if m.ThreadTs != "" {
+		_, root := s.find(channel, m.ThreadTs)
+		if root == nil {
+			return nil, "thread_not_found"
+		}
+		root.ThreadTs = root.Ts
+	}
+	posted := s.add(channel, m)
+	return map[string]any{"channel": channel, "ts": posted.Ts, "message": posted}, ""
+}
+
+func (s *Server) chatPostEphemeral(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if str(params, "channel") == "" {
+		return nil, "channel_not_found"
+	}
+	if str(params, "user") == "" {
+		return nil, "user_not_in_channel"
+	}
+	s.counter++
+	return map[string]any{"message_ts": strconv.FormatInt(tsBase+s.counter, 10) + ".000100"}, ""
+}
+
+func (s *Server) chatUpdate(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel := str(params, "channel")
+	_, m := s.find(channel, str(params, "ts"))
+	if m == nil {
+		return nil, "message_not_found"
+	}
+	if m.BotId != s.BotId {
+		// Bots can only edit their own messages.
+		return nil, "cant_update_message"
+	}
+	if _, ok := params["text"]; ok {
+		m.Text = str(params, "text")
+	}
+	if b := raw(params, "blocks"); b != nil {
+		m.Blocks = b
+	}
+	if a := raw(params, "attachments"); a != nil {
+		m.Attachments = a
+	}
+	return map[string]any{"channel": channel, "ts": m.Ts, "text": m.Text}, ""
+}
+
+func (s *Server) chatDelete(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel := str(params, "channel")
+	i, m := s.find(channel, str(params, "ts"))
+	if m == nil || m.Subtype == "tombstone" {
+		return nil, "message_not_found"
+	}
+	ts := m.Ts
+	if (m.ThreadTs == "" || m.ThreadTs == m.Ts) && len(s.thread(channel, m.Ts)) > 1 {
+		// Like Slack, a deleted root with replies stays as a placeholder
+		// until its replies are gone.
+		*m = Message{Ts: m.Ts, Subtype: "tombstone", Text: "This message was deleted.", Channel: channel}
+		return map[string]any{"channel": channel, "ts": ts}, ""
+	}
+	s.messages[channel] = append(s.messages[channel][:i], s.messages[channel][i+1:]...)
+	if m.ThreadTs != "" && m.ThreadTs != m.Ts {
+		if j, root := s.find(channel, m.ThreadTs); root != nil && root.Subtype == "tombstone" && len(s.thread(channel, root.Ts)) == 1 {
+			s.messages[channel] = append(s.messages[channel][:j], s.messages[channel][j+1:]...)
+		}
+	}
+	return map[string]any{"channel": channel, "ts": ts}, ""
+}
+
+func (s *Server) chatGetPermalink(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel, ts := str(params, "channel"), str(params, "message_ts")
+	if _, m := s.find(channel, ts); m == nil {
+		return nil, "message_not_found"
+	}
+	return map[string]any{
+		"channel":   channel,
+		"permalink": s.URL + "/archives/" + channel + "/p" + strings.ReplaceAll(ts, ".", ""),
+	}, ""
+}
+
+func (s *Server) usersInfo(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[str(params, "user")]
+	if !ok {
+		return nil, "user_not_found"
+	}
+	return map[string]any{"user": u}, ""
+}
+
+func (s *Server) filesInfo(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[str(params, "file")]
+	if !ok {
+		return nil, "file_not_found"
+	}
+	return map[string]any{"file": f}, ""
+}
+
+func (s *Server) filesGetUploadURLExternal(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := str(params, "filename")
+	length, err := strconv.Atoi(str(params, "length"))
+	if name == "" || err != nil {
+		return nil, "invalid_arguments"
+	}
+	f := s.addFile(name, "", nil)
+	f.Size = length
+	return map[string]any{"upload_url": s.URL + "/upload/" + f.Id, "file_id": f.Id}, ""
+}
+
+func (s *Server) filesCompleteUploadExternal(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var uploads []struct {
+		Id    string `json:"id"`
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(raw(params, "files"), &uploads); err != nil || len(uploads) == 0 {
+		return nil, "invalid_arguments"
+	}
+	var files []File
+	for _, u := range uploads {
+		f, ok := s.files[u.Id]
+		if !ok {
+			return nil, "file_not_found"
+		}
+		if u.Title != "" {
+			f.Title = u.Title
+		}
+		files = append(files, *f)
+	}
+	channel := str(params, "channel_id")
+	if channel != "" {
+		m := Message{ThreadTs: str(params, "thread_ts"), BotId: s.BotId, Text: str(params, "initial_comment"), Files: files}
+		if m.ThreadTs != "" {
+			if _, root := s.find(channel, m.ThreadTs); root != nil {
+				root.ThreadTs = root.Ts
+			}
+		}
+		posted := s.add(channel, m)
+		if !s.LateShares {
+			share := map[string][]Share{channel: {{Ts: posted.Ts, ThreadTs: posted.ThreadTs}}}
+			for i := range files {
+				files[i].Shares = &Shares{Public: share}
+				if !strings.HasPrefix(channel, "C") {
+					files[i].Shares = &Shares{Private: share}
+				}
+			}
+		}
+	}
+	return map[string]any{"files": files}, ""
+}
+
+func (s *Server) viewsOpen(params map[string]any) (map[string]any, string) {
+	if str(params, "trigger_id") == "" {
+		return nil, "invalid_trigger_id"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counter++
+	return map[string]any{"view": map[string]any{"id": "V" + strconv.FormatInt(s.counter, 10)}}, ""
+}
@@ -0,0 +1,509 @@
+// Package slacktest runs an in-memory stand-in for the Slack Web API on an
+// httptest server. It keeps channels, threads, users and files in memory and
+// records every call, so the bot can be driven end to end without a real
+// workspace:
+//
+//	fake := slacktest.NewServer()
+//	defer fake.Close()
+//	client := &slack.Client{APIURL: fake.APIURL()}
+//	fake.AddUser(slacktest.User{Id: "U1", RealName: "Jane"})
+//	root := fake.AddMessage("C1", slacktest.Message{User: "U1", Text: "help"})
+//	req := slacktest.SignedRequest("secret", "/", callbackBody)
+package slacktest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Call struct {
+	Method string
+	Token  string
+	Params map[string]any
+	Time   time.Time
+}
+
+type Reaction struct {
+	Name  string   `json:"name"`
+	Users []string `json:"users"`
+	Count int      `json:"count"`
+}
+
+type File struct {
+	Id         string  `json:"id"`
+	Name       string  `json:"name"`
+	Title      string  `json:"title"`
+	MimeType   string  `json:"mimetype"`
+	Size       int     `json:"size"`
+	UrlPrivate string  `json:"url_private"`
+	Shares     *Shares `json:"shares,omitempty"`
+	content    []byte
+}
+
+type Share struct {
+	Ts       string `json:"ts"`
+	ThreadTs string `json:"thread_ts,omitempty"`
+}
+
+type Shares struct {
+	Public  map[string][]Share `json:"public,omitempty"`
+	Private map[string][]Share `json:"private,omitempty"`
+}
+
+type Message struct {
+	Ts          string            `json:"ts"`
+	ThreadTs    string            `json:"thread_ts,omitempty"`
+	Subtype     string            `json:"subtype,omitempty"`
+	User        string            `json:"user,omitempty"`
+	BotId       string            `json:"bot_id,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	IconUrl     string            `json:"icon_url,omitempty"`
+	Icons       map[string]string `json:"icons,omitempty"`
+	Text        string            `json:"text"`
+	Blocks      json.RawMessage   `json:"blocks,omitempty"`
+	Attachments json.RawMessage   `json:"attachments,omitempty"`
+	Files       []File            `json:"files,omitempty"`
+	Reactions   []Reaction        `json:"reactions,omitempty"`
+	Channel     string            `json:"-"`
+}
+
+type Profile struct {
+	Image72 string `json:"image_72,omitempty"`
+}
+
+type User struct {
+	Id       string  `json:"id"`
+	TeamId   string  `json:"team_id"`
+	Name     string  `json:"name,omitempty"`
+	RealName string  `json:"real_name"`
+	Profile  Profile `json:"profile"`
+	IsBot    bool    `json:"is_bot"`
+}
+
+type failure struct {
+	err        string
+	extra      map[string]any
+	status     int
+	retryAfter int
+}
+
+// Server is the fake Slack. With LateShares set, files.completeUploadExternal
+// replies without the shares of the files, the way Slack does while it
+// still shares them in the background.
+type Server struct {
+	URL        string
+	BotId      string
+	LateShares bool
+	server     *httptest.Server
+	mu         sync.Mutex
+	counter    int64
+	messages   map[string][]*Message
+	users      map[string]User
+	tokens     map[string]string
+	files      map[string]*File
+	calls      []Call
+	failures   map[string][]failure
+	sockets    []*socket
+	acks       []Ack
+	handlers   map[string]func(map[string]any) (map[string]any, string)
+}
+
+const tsBase = 1700000000
+
+func NewServer() *Server {
+	s := &Server{
+		BotId:    "B0FAKE",
+		messages: make(map[string][]*Message),
+		users:    make(map[string]User),
+		tokens:   make(map[string]string),
+		files:    make(map[string]*File),
+		failures: make(map[string][]failure),
+	}
+	s.handlers = map[string]func(map[string]any) (map[string]any, string){
+		"conversations.replies":        s.conversationsReplies,
+		"conversations.history":        s.conversationsHistory,
+		"auth.test":                    s.authTest,
+		"chat.postMessage":             s.chatPostMessage,
+		"chat.postEphemeral":           s.chatPostEphemeral,
+		"chat.update":                  s.chatUpdate,
+		"chat.delete":                  s.chatDelete,
+		"chat.getPermalink":            s.chatGetPermalink,
+		"users.info":                   s.usersInfo,
+		"files.info":                   s.filesInfo,
+		"files.getUploadURLExternal":   s.filesGetUploadURLExternal,
+		"files.completeUploadExternal": s.filesCompleteUploadExternal,
+		"apps.connections.open":        s.appsConnectionsOpen,
+		"conversations.join":           s.conversationsJoin,
+		"views.open":                   s.viewsOpen,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", s.serveAPI)
+	mux.HandleFunc("/upload/", s.serveUpload)
+	mux.HandleFunc("/files/", s.serveFile)
+	mux.HandleFunc("/socket", s.serveSocket)
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, sock := range s.sockets {
+		sock.conn.Close()
+	}
+	s.mu.Unlock()
+	s.server.Close()
+}
+
+// APIURL is the value for the bot's Slack API base URL.
+func (s *Server) APIURL() string {
+	return s.URL + "/api/"
+}
+
+// Handle adds or replaces the implementation of an API method. The handler
+// returns the response fields besides "ok", or a Slack error code. Handle
+// returns the handler it replaced, so a test can wrap it.
+func (s *Server) Handle(method string, h func(params map[string]any) (map[string]any, string)) func(params map[string]any) (map[string]any, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.handlers[method]
+	s.handlers[method] = h
+	return previous
+}
+
+// Fail makes the next call of the method return the Slack error code.
+func (s *Server) Fail(method string, err string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], failure{err: err})
+}
+
+// FailWith is Fail with extra response fields, such as needed and provided
+// for missing_scope.
+func (s *Server) FailWith(method string, err string, extra map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], failure{err: err, extra: extra})
+}
+
+// RateLimit makes the next call of the method answer HTTP 429 with the
+// given Retry-After.
+func (s *Server) RateLimit(method string, retryAfter int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], failure{status: http.StatusTooManyRequests, retryAfter: retryAfter})
+}
+
+func (s *Server) AddUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.Id] = u
+}
+
+// AddToken makes auth.test report the user a token belongs to. Unknown
+// tokens get invalid_auth.
+func (s *Server) AddToken(token string, user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = user
+}
+
+// AddFile stores a file and returns it with url_private pointing at the
+// fake server, ready to be attached to a message.
+func (s *Server) AddFile(name string, mimetype string, content []byte) File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.addFile(name, mimetype, content)
+}
+
+func (s *Server) addFile(name string, mimetype string, content []byte) *File {
+	s.counter++
+	id := "F" + strconv.FormatInt(s.counter, 10)
+	f := &File{
+		Id:         id,
+		Name:       name,
+		Title:      name,
+		MimeType:   mimetype,
+		Size:       len(content),
+		UrlPrivate: s.URL + "/files/" + id + "/" + url.PathEscape(name),
+		content:    content,
+	}
+	s.files[id] = f
+	return f
+}
+
+// FileContent returns what was uploaded or stored for the file.
+func (s *Server) FileContent(id string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[id]; ok {
+		return f.content
+	}
+	return nil
+}
+
+// AddMessage stores a message in the channel and returns its ts. A message
+// with ThreadTs set becomes a reply in that thread.
+func (s *Server) AddMessage(channel string, m Message) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(channel, m).Ts
+}
+
+func (s *Server) add(channel string, m Message) *Message {
+	s.counter++
+	m.Ts = strconv.FormatInt(tsBase+s.counter, 10) + ".000100"
+	m.Channel = channel
+	s.messages[channel] = append(s.messages[channel], &m)
+	return &m
+}
+
+// Messages returns all messages of the channel, threads included, in ts order.
+func (s *Server) Messages(channel string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var mm []Message
+	for _, m := range s.messages[channel] {
+		mm = append(mm, *m)
+	}
+	return mm
+}
+
+// Thread returns the root and the replies of a thread in ts order.
+func (s *Server) Thread(channel string, ts string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var mm []Message
+	for _, m := range s.thread(channel, ts) {
+		mm = append(mm, *m)
+	}
+	return mm
+}
+
+func (s *Server) thread(channel string, ts string) []*Message {
+	var mm []*Message
+	for _, m := range s.messages[channel] {
+		if m.Ts == ts || m.ThreadTs == ts {
+			mm = append(mm, m)
+		}
+	}
+	return mm
+}
+
+func (s *Server) find(channel string, ts string) (int, *Message) {
+	for i, m := range s.messages[channel] {
+		if m.Ts == ts {
+			return i, m
+		}
+	}
+	return -1, nil
+}
+
+// Calls returns every recorded API call, optionally only of given methods.
+func (s *Server) Calls(methods ...string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calls []Call
+	for _, c := range s.calls {
+		if len(methods) == 0 {
+			calls = append(calls, c)
+			continue
+		}
+		for _, m := range methods {
+			if c.Method == m {
+				calls = append(calls, c)
+			}
+		}
+	}
+	return calls
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	params, err := readParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, ok := params["token"]; !ok && token != "" {
+		params["token"] = token
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, Call{Method: method, Token: token, Params: params, Time: time.Now()})
+	var fail *failure
+	if ff := s.failures[method]; len(ff) > 0 {
+		fail = &ff[0]
+		s.failures[method] = ff[1:]
+	}
+	handler, ok := s.handlers[method]
+	s.mu.Unlock()
+
+	if fail != nil && fail.status != 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(fail.retryAfter))
+		w.WriteHeader(fail.status)
+		return
+	}
+	var result map[string]any
+	var slackErr string
+	switch {
+	case fail != nil:
+		result, slackErr = fail.extra, fail.err
+	case !ok:
+		slackErr = "unknown_method"
+	default:
+		result, slackErr = handler(params)
+	}
+	if result == nil {
+		result = make(map[string]any)
+	} else {
+		copied := make(map[string]any)
+		for k, v := range result {
+			copied[k] = v
+		}
+		result = copied
+	}
+	result["ok"] = slackErr == ""
+	if slackErr != "" {
+		result["error"] = slackErr
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func readParams(r *http.Request) (map[string]any, error) {
+	params := make(map[string]any)
+	for k, v := range r.URL.Query() {
+		params[k] = v[0]
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") && len(body) > 0 {
+		var data map[string]any
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, err
+		}
+		for k, v := range data {
+			params[k] = v
+		}
+		return params, nil
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range form {
+		params[k] = v[0]
+	}
+	return params, nil
+}
+
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/upload/")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f.content = body
+	f.Size = len(body)
+	f.MimeType = r.Header.Get("Content-Type")
+	fmt.Fprint(w, "OK - "+strconv.Itoa(len(body)))
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		http.Error(w, "not authed", http.StatusForbidden)
+		return
+	}
+	id := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/files/"), "/", 2)[0]
+	s.mu.Lock()
+	f, ok := s.files[id]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", f.MimeType)
+	w.Write(f.content)
+}
+
+// SignedRequest builds a request to the bot signed the way Slack signs
+// event, command and interactivity deliveries.
+func SignedRequest(secret string, target string, body []byte) *http.Request {
+	req := httptest.NewRequest("POST", target, strings.NewReader(string(body)))
+	Sign(req, secret, body)
+	return req
+}
+
+func Sign(req *http.Request, secret string, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+func str(params map[string]any, key string) string {
+	switch v := params[key].(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// raw returns a structured parameter as JSON, whether it was sent as a JSON
+// value or as a JSON-encoded form string.
+func raw(params map[string]any, key string) json.RawMessage {
+	switch v := params[key].(type) {
+	case nil:
+		return nil
+	case string:
+		if v == "" {
+			return nil
+		}
+		return json.RawMessage(v)
+	default:
+		b, _ := json.Marshal(v)
+		return b
+	}
+}
+
+func sortByTs(mm []*Message) {
+	sort.SliceStable(mm, func(i, j int) bool {
+		a, _ := strconv.ParseFloat(mm[i].Ts, 64)
+		b, _ := strconv.ParseFloat(mm[j].Ts, 64)
+		return a < b
+	})
+}
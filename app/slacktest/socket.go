@@ -0,0 +1,207 @@
+package slacktest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// socket is the server side of a Socket Mode connection.
+type socket struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	wmu    sync.Mutex
+	closed chan struct{}
+}
+
+func (s *Server) appsConnectionsOpen(params map[string]any) (map[string]any, string) {
+	return map[string]any{"url": "ws" + strings.TrimPrefix(s.URL, "http") + "/socket"}, ""
+}
+
+func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	brw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	brw.Flush()
+	sock := &socket{conn: conn, br: brw.Reader, closed: make(chan struct{})}
+
+	s.mu.Lock()
+	s.sockets = append(s.sockets, sock)
+	s.mu.Unlock()
+	sock.write(Envelope{Type: "hello"})
+
+	go func() {
+		defer s.dropSocket(sock)
+		for {
+			opcode, payload, err := sock.read()
+			if err != nil || opcode == 0x8 {
+				return
+			}
+			var ack Ack
+			if json.Unmarshal(payload, &ack) == nil && ack.EnvelopeId != "" {
+				s.mu.Lock()
+				s.acks = append(s.acks, ack)
+				s.mu.Unlock()
+			}
+		}
+	}()
+}
+
+func (s *Server) dropSocket(sock *socket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sock.conn.Close()
+	close(sock.closed)
+	for i, c := range s.sockets {
+		if c == sock {
+			s.sockets = append(s.sockets[:i], s.sockets[i+1:]...)
+			return
+		}
+	}
+}
+
+type Envelope struct {
+	EnvelopeId string `json:"envelope_id,omitempty"`
+	Type       string `json:"type"`
+	Reason     string `json:"reason,omitempty"`
+	Payload    any    `json:"payload,omitempty"`
+}
+
+// SendEnvelope pushes an events_api, slash_commands or interactive envelope
+// to the newest Socket Mode connection and returns its envelope_id.
+func (s *Server) SendEnvelope(envelopeType string, payload any) (string, error) {
+	s.mu.Lock()
+	s.counter++
+	id := "env-" + strconv.FormatInt(s.counter, 10)
+	var sock *socket
+	if len(s.sockets) > 0 {
+		sock = s.sockets[len(s.sockets)-1]
+	}
+	s.mu.Unlock()
+	if sock == nil {
+		return "", errors.New("no Socket Mode connection")
+	}
+	return id, sock.write(Envelope{EnvelopeId: id, Type: envelopeType, Payload: payload})
+}
+
+// Disconnect asks connected clients to reconnect, like Slack does before
+// rotating its servers. It returns once the clients closed the connections,
+// or after a few seconds when they do not.
+func (s *Server) Disconnect(reason string) {
+	s.mu.Lock()
+	sockets := append([]*socket{}, s.sockets...)
+	s.mu.Unlock()
+	for _, sock := range sockets {
+		sock.write(Envelope{Type: "disconnect", Reason: reason})
+	}
+	timeout := time.After(5 * time.Second)
+	for _, sock := range sockets {
+		select {
+		case <-sock.closed:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// Connections is the number of open Socket Mode connections.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sockets)
+}
+
+// Ack is the acknowledgement of an envelope. The payload is the response to
+// an interactive envelope, such as the response_action of a view.
+type Ack struct {
+	EnvelopeId string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+}
+
+// Acks returns the acknowledgements sent by clients so far.
+func (s *Server) Acks() []Ack {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Ack{}, s.acks...)
+}
+
+func (sock *socket) write(envelope Envelope) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	sock.wmu.Lock()
+	defer sock.wmu.Unlock()
+	frame := []byte{0x81}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	_, err = sock.conn.Write(append(frame, payload...))
+	return err
+}
+
+func (sock *socket) read() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(sock.br, head[:]); err != nil {
+		return 0, nil, err
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(sock.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(sock.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	if head[1]&0x80 != 0 {
+		if _, err := io.ReadFull(sock.br, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(sock.br, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return head[0] & 0x0F, payload, nil
+}
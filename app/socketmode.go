@@ -0,0 +1,15 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+)
+
+func handleSlashCommand(ctx context.Context, q url.Values) error {
+	switch q.Get("command") {
+	case "/showautomoves":
+		return showAutomoves(ctx, q)
+	}
+	return errors.New("unknown command")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+var syncWindow = 24 * time.Hour
+
+// syncMessage applies an edit or deletion in the source channel to the
+// copies of the message. Only moves that kept the originals are followed,
+// and only for syncWindow after the move.
+func syncMessage(ctx context.Context, event slack.Event) {
+	switch event.Subtype {
+	case "message_changed":
+		if event.Message == nil {
+			return
+		}
+		for _, kept := range records.Copies(event.Channel, event.Message.Ts, syncWindow) {
+			move := Automove{From: kept.Record.From, To: kept.Record.To}
+			msg, _ := move.compose(ctx, *event.Message)
+			err := api.UpdateMessage(ctx, slack.UpdateMessageRequest{
+				Channel:     kept.Record.To,
+				Ts:          kept.Ts,
+				Text:        msg.Text,
+				Blocks:      msg.Blocks,
+				Attachments: msg.Attachments,
+			})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Cannot update the copy of "+event.Message.Ts+" in "+kept.Record.To+": "+describe(err))
+			}
+		}
+	case "message_deleted":
+		for _, kept := range records.Copies(event.Channel, event.DeletedTs, syncWindow) {
+			err := deleteCopy(ctx, kept)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Cannot delete the copy of "+event.DeletedTs+" in "+kept.Record.To+": "+describe(err))
+				continue
+			}
+			err = records.Forget(kept.Record, event.DeletedTs)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Cannot record the deleted copy: "+err.Error())
+			}
+		}
+	}
+}
+
+// deleteCopy deletes the copy of a message, the reply with its files first.
+func deleteCopy(ctx context.Context, kept keptCopy) error {
+	for _, ts := range []string{kept.Files, kept.Ts} {
+		if ts == "" {
+			continue
+		}
+		err := api.DeleteBotMessage(ctx, kept.Record.To, ts)
+		if err != nil && !slack.IsError(err, "message_not_found") {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+// refreshMargin is how long before expiry a rotating token is refreshed.
+const refreshMargin = 10 * time.Minute
+
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	UserId       string    `json:"user_id,omitempty"`
+	Revoked      bool      `json:"revoked,omitempty"`
+}
+
+func (t Token) expiresSoon() bool {
+	return t.RefreshToken != "" && !t.ExpiresAt.IsZero() && time.Until(t.ExpiresAt) < refreshMargin
+}
+
+// TokenStore keeps the bot and user tokens. Rotating tokens are refreshed
+// before they expire and written to tokens.json in the data directory, so a
+// restart picks up the latest refresh token instead of a spent one.
+type TokenStore struct {
+	mu   sync.Mutex
+	path string
+	Bot  Token `json:"bot"`
+	User Token `json:"user"`
+}
+
+var tokens *TokenStore
+
+var errTokenRevoked = errors.New("The token was revoked, the app has to be reinstalled")
+
+// loadTokens reads the saved tokens. Tokens missing there are taken from the
+// config. A refresh token given there is spent right away, since the expiry
+// of the token from the config is unknown.
+func loadTokens(db *Database) (*TokenStore, error) {
+	ts := &TokenStore{path: filepath.Join(db.dataDir(), "tokens.json")}
+	data, err := os.ReadFile(ts.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(data, ts)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse %s: %w", ts.path, err)
+		}
+	}
+	if ts.Bot.AccessToken == "" {
+		ts.Bot = configToken(db.SlackBotToken, db.SlackBotRefreshToken)
+	}
+	if ts.User.AccessToken == "" {
+		ts.User = configToken(db.SlackUserToken, db.SlackUserRefreshToken)
+	}
+	return ts, nil
+}
+
+func (ts *TokenStore) BotToken(ctx context.Context) (string, error) {
+	return ts.get(ctx, &ts.Bot)
+}
+
+func (ts *TokenStore) UserToken(ctx context.Context) (string, error) {
+	return ts.get(ctx, &ts.User)
+}
+
+func (ts *TokenStore) get(ctx context.Context, t *Token) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if t.Revoked {
+		return "", errTokenRevoked
+	}
+	if t.expiresSoon() {
+		err := ts.refresh(ctx, t)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error()+". Using the current token")
+		}
+	}
+	return t.AccessToken, nil
+}
+
+// refresh trades the refresh token for a new token pair. The caller holds
+// ts.mu, so concurrent calls never spend the same refresh token twice.
+func (ts *TokenStore) refresh(ctx context.Context, t *Token) error {
+	users, err := api.OauthV2Access(ctx, slack.OAuthAccessRequest{
+		GrantType:    "refresh_token",
+		RefreshToken: t.RefreshToken,
+		ClientId:     slackClientID,
+		ClientSecret: slackClientSecret,
+	})
+	if err != nil {
+		return fmt.Errorf("Cannot refresh token: %w", err)
+	}
+	if len(users) == 0 {
+		return errors.New("Cannot refresh token: no token in the oauth.v2.access reply")
+	}
+	userId := t.UserId
+	*t = tokenOf(users[0])
+	if t.UserId == "" {
+		t.UserId = userId
+	}
+	err = ts.save()
+	if err != nil {
+		return fmt.Errorf("Cannot save the refreshed token: %w", err)
+	}
+	return nil
+}
+
+// Install keeps the tokens of a fresh installation from the OAuth flow.
+func (ts *TokenStore) Install(users []slack.User) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, u := range users {
+		switch u.TokenType {
+		case "bot":
+			ts.Bot = tokenOf(u)
+		case "user":
+			ts.User = tokenOf(u)
+		}
+	}
+	return ts.save()
+}
+
+// keepFresh refreshes tokens in the background, so an idle bot does not
+// wake up with an expired token and a refresh token nobody used in time.
+func (ts *TokenStore) keepFresh(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		for _, get := range []func(context.Context) (string, error){ts.BotToken, ts.UserToken} {
+			_, err := get(ctx)
+			if err != nil && !errors.Is(err, errTokenRevoked) {
+				fmt.Fprintln(os.Stderr, err.Error())
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resolveOwners asks Slack who the tokens without a user belong to, such as
+// the ones from config.json, so tokens_revoked events can be matched to
+// them. A token Slack reports as revoked is marked so right away.
+func (ts *TokenStore) resolveOwners(ctx context.Context) error {
+	for _, user := range []bool{false, true} {
+		t := &ts.Bot
+		if user {
+			t = &ts.User
+		}
+		ts.mu.Lock()
+		unknown := t.AccessToken != "" && !t.Revoked && t.UserId == ""
+		ts.mu.Unlock()
+		if !unknown {
+			continue
+		}
+		owner, err := api.AuthTest(ctx, user)
+		revoked := slack.IsError(err, "token_revoked", "account_inactive")
+		if err != nil && !revoked {
+			return fmt.Errorf("Cannot resolve the owner of a token: %w", err)
+		}
+		ts.mu.Lock()
+		if revoked {
+			t.Revoked = true
+		} else {
+			t.UserId = owner.UserId
+		}
+		err = ts.save()
+		ts.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("Cannot save the token owners: %w", err)
+		}
+	}
+	return nil
+}
+
+// Revoke marks tokens invalid after Slack revoked them. Only tokens whose
+// user is known can match. It reports what was revoked.
+func (ts *TokenStore) Revoke(bots []string, users []string) (bot bool, user bool, err error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	bot = revokes(&ts.Bot, bots)
+	user = revokes(&ts.User, users)
+	if !bot && !user {
+		return false, false, nil
+	}
+	return bot, user, ts.save()
+}
+
+func revokes(t *Token, ids []string) bool {
+	if t.Revoked || t.AccessToken == "" {
+		return false
+	}
+	for _, id := range ids {
+		if t.UserId != "" && t.UserId == id {
+			t.Revoked = true
+			return true
+		}
+	}
+	return false
+}
+
+// RevokeAll marks both tokens invalid after the app was uninstalled.
+func (ts *TokenStore) RevokeAll() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.Bot.Revoked = true
+	ts.User.Revoked = true
+	return ts.save()
+}
+
+// Valid reports whether the bot and the user token can be used.
+func (ts *TokenStore) Valid() (bot bool, user bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.Bot.AccessToken != "" && !ts.Bot.Revoked, ts.User.AccessToken != "" && !ts.User.Revoked
+}
+
+// BotUserId is the user id of the bot, known when the app was installed via
+// /setup.
+func (ts *TokenStore) BotUserId() string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.Bot.UserId
+}
+
+func (ts *TokenStore) save() error {
+	data, err := json.MarshalIndent(ts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(ts.path, data, 0600)
+}
+
+func configToken(access string, refresh string) Token {
+	t := Token{AccessToken: access, RefreshToken: refresh}
+	if refresh != "" {
+		t.ExpiresAt = time.Now()
+	}
+	return t
+}
+
+func tokenOf(u slack.User) Token {
+	t := Token{AccessToken: u.AccessToken, RefreshToken: u.RefreshToken, UserId: u.Id}
+	if u.ExpiresIn > 0 {
+		t.ExpiresAt = time.Now().Add(time.Duration(u.ExpiresIn) * time.Second)
+	}
+	return t
+}
+
+// writeFile replaces a file atomically, so a crash never leaves it half
+// written.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+const defaultTombstone = "This thread was moved to {channel} by {user}: {link}"
+
+// tombstone builds the message that tells readers of the source where a
+// moved thread went, or nil when tombstones are turned off.
+func (a Automove) tombstone(ctx context.Context, record *MoveRecord) *slack.PostMessageRequest {
+	if settings.NoTombstone || a.restore {
+		return nil
+	}
+	link := "<#" + a.To + ">"
+	permalink, err := api.GetPermalink(ctx, a.To, record.DestTs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot get the link to the moved thread: "+describe(err))
+	} else {
+		link = "<" + permalink + "|View the thread>"
+	}
+	text := settings.Tombstone
+	if text == "" {
+		text = defaultTombstone
+	}
+	user := "<@" + a.User.Id + ">"
+	if a.User.Id == "" {
+		user = "the bot"
+	}
+	text = strings.NewReplacer("{channel}", "<#"+a.To+">", "{user}", user, "{link}", link).Replace(text)
+	return &slack.PostMessageRequest{
+		Channel: a.From,
+		Text:    text,
+		Blocks:  []slack.Block{{Type: "context", Elements: []slack.Element{{Type: "mrkdwn", Text: ":arrow_right: " + text}}}},
+	}
+}
+
+// replaceRoot turns the root of the thread into the tombstone, which keeps
+// old links to it working. It only succeeds when the bot posted the root.
+func (a Automove) replaceRoot(ctx context.Context, record *MoveRecord, tombstone *slack.PostMessageRequest) bool {
+	err := api.UpdateMessage(ctx, slack.UpdateMessageRequest{
+		Channel: a.From,
+		Ts:      record.Ts,
+		Text:    tombstone.Text,
+		Blocks:  tombstone.Blocks,
+	})
+	if err != nil {
+		if !slack.IsError(err, "cant_update_message", "edit_window_closed") {
+			fmt.Fprintln(os.Stderr, "Cannot replace the root with the tombstone: "+describe(err))
+		}
+		return false
+	}
+	record.Tombstone = record.Ts
+	return true
+}
+
+// postTombstone posts the tombstone in place of the deleted root.
+func (a Automove) postTombstone(ctx context.Context, record *MoveRecord, tombstone *slack.PostMessageRequest) {
+	ts, err := api.PostMessage(ctx, *tombstone, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot post the tombstone: "+describe(err))
+		return
+	}
+	record.Tombstone = ts
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+// rollbackTimeout bounds taking back a partial copy. The move's own context
+// may be done by then, e.g. when it timed out.
+const rollbackTimeout = time.Minute
+
+// transaction tracks the messages a move posted in the destination, so a
+// move that fails half way can take them back. It is the journal of the
+// move as well: every posted message is written to the journal directory in
+// data_dir, and a move interrupted by shutdown or a crash continues from it
+// on the next start. Copies maps the ts of every copied message to the ts of
+// its copy, Files the ts of a message with files to the reply its files were
+// shared in. DestTs is the root of the copy. Partial holds what was posted
+// for a message whose copy is not complete yet, such as the text of a
+// message whose files are still being shared. Tombstone is the tombstone
+// left in the source while the originals are deleted.
+type transaction struct {
+	path      string
+	Channel   string              `json:"channel"`
+	Source    string              `json:"source"`
+	Ts        string              `json:"ts"`
+	DestTs    string              `json:"dest_ts,omitempty"`
+	Copies    map[string]string   `json:"copies"`
+	Files     map[string]string   `json:"files,omitempty"`
+	Posted    []string            `json:"posted,omitempty"`
+	Partial   map[string][]string `json:"partial,omitempty"`
+	Tombstone string              `json:"tombstone,omitempty"`
+}
+
+// openTransaction picks up the journal of an interrupted move of the thread
+// to the same destination, or starts a new one.
+func openTransaction(source string, channel string, ts string) (*transaction, error) {
+	path := filepath.Join(settings.dataDir(), "journal", source+"-"+ts+".json")
+	t := &transaction{path: path, Channel: channel, Source: source, Ts: ts, Copies: make(map[string]string), Files: make(map[string]string), Partial: make(map[string][]string)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read the journal: %w", err)
+	}
+	var journal transaction
+	err = json.Unmarshal(data, &journal)
+	if err != nil || journal.Channel != channel {
+		// Unreadable, or a move elsewhere that cannot be continued here.
+		fmt.Fprintln(os.Stderr, "Discarding the journal "+path)
+		return t, nil
+	}
+	journal.path = path
+	if journal.Copies == nil {
+		journal.Copies = make(map[string]string)
+	}
+	if journal.Files == nil {
+		journal.Files = make(map[string]string)
+	}
+	if journal.Partial == nil {
+		journal.Partial = make(map[string][]string)
+	}
+	fmt.Fprintln(os.Stderr, "Resuming the move of "+ts+" to "+channel+", "+strconv.Itoa(len(journal.Copies))+" messages were copied already")
+	return &journal, nil
+}
+
+// add records a message posted in the destination for a message of the
+// thread. The first one is the root of the copy.
+func (t *transaction) add(source string, ts string) {
+	if t.DestTs == "" {
+		t.DestTs = ts
+	}
+	t.Posted = append(t.Posted, ts)
+	t.Partial[source] = append(t.Partial[source], ts)
+	t.save()
+}
+
+// copied records that a message of the thread is copied completely, with
+// the reply its files were shared in when it had any.
+func (t *transaction) copied(ts string, dest string, files string) {
+	t.Copies[ts] = dest
+	if files != "" {
+		t.Files[ts] = files
+	}
+	delete(t.Partial, ts)
+	t.save()
+}
+
+// tombstoned records the tombstone left in the source.
+func (t *transaction) tombstoned(ts string) {
+	if ts == "" {
+		return
+	}
+	t.Tombstone = ts
+	t.save()
+}
+
+// discardPartial deletes what an interrupted move posted for messages it
+// did not finish copying, so they are copied again without duplicates.
+func (t *transaction) discardPartial(ctx context.Context) error {
+	for source, posted := range t.Partial {
+		for i := len(posted) - 1; i >= 0; i-- {
+			err := api.DeleteBotMessage(ctx, t.Channel, posted[i])
+			if err != nil && !slack.IsError(err, "message_not_found") {
+				return fmt.Errorf("Cannot delete the partial copy of %s: %w", source, err)
+			}
+			for j, ts := range t.Posted {
+				if ts == posted[i] {
+					t.Posted = append(t.Posted[:j:j], t.Posted[j+1:]...)
+					break
+				}
+			}
+			if t.DestTs == posted[i] {
+				t.DestTs = ""
+			}
+		}
+		delete(t.Partial, source)
+		t.save()
+	}
+	return nil
+}
+
+func (t *transaction) save() {
+	data, err := json.Marshal(t)
+	if err == nil {
+		err = writeFile(t.path, data, 0600)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot write the journal, the move cannot be resumed: "+err.Error())
+	}
+}
+
+// commit drops the journal of a finished copy.
+func (t *transaction) commit() {
+	err := os.Remove(t.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, "Cannot remove the journal: "+err.Error())
+	}
+}
+
+// rollback deletes the partial copy, newest message first. When that fails,
+// what is left is marked as incomplete. A copy cut short by shutdown is kept
+// for the next start instead. It returns cause, telling what became of the
+// partial copy.
+func (t *transaction) rollback(ctx context.Context, cause error) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return cause
+	}
+	t.commit()
+	if len(t.Posted) == 0 {
+		return cause
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	left := len(t.Posted)
+	for ; left > 0; left-- {
+		err := api.DeleteBotMessage(ctx, t.Channel, t.Posted[left-1])
+		if err != nil && !slack.IsError(err, "message_not_found") {
+			fmt.Fprintln(os.Stderr, "Cannot delete the partial copy "+t.Posted[left-1]+": "+describe(err))
+			break
+		}
+	}
+	if left == 0 {
+		fmt.Fprintln(os.Stderr, "Removed the partial copy of "+strconv.Itoa(len(t.Posted))+" messages from "+t.Channel)
+		return fmt.Errorf("%w. The partial copy was removed, the original was not changed", cause)
+	}
+	_, err := api.PostMessage(ctx, slack.PostMessageRequest{
+		Channel:   t.Channel,
+		ThreadTs:  t.Posted[0],
+		Broadcast: true,
+		Text:      ":warning: This copy is incomplete, the move failed. The original thread is still in <#" + t.Source + ">.",
+	}, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot mark the partial copy as incomplete: "+describe(err))
+	}
+	return fmt.Errorf("%w. The partial copy in <#%s> is incomplete, the original was not changed", cause, t.Channel)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+var undoWindow = time.Hour
+var undoReaction = "leftwards_arrow_with_hook"
+
+// requestUndo queues moving a thread back from the destination, asked for on
+// its copy there.
+func requestUndo(ctx context.Context, record MoveRecord, user slack.User) {
+	back := Automove{From: record.To, To: record.From, Mode: modeMove, User: user}
+	if !settings.IsPermittedUser(user.Id) {
+		notify(ctx, back, "You are not permitted to undo moves.")
+		return
+	}
+	if time.Since(record.Finished) > undoWindow {
+		notify(ctx, back, "This thread was moved more than "+undoWindow.String()+" ago, the move cannot be undone anymore.")
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Undo of the move of "+record.Ts+" to "+record.To+" asked by "+user.Id)
+	err := queue.Add(Job{Move: back, User: user.Id, TeamId: user.TeamId, Ts: record.DestTs, Undo: true})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		notifyMoveFailure(ctx, back, err)
+	}
+}
+
+// undo rebuilds the thread in the channel it was moved from and removes the
+// copy. A copy or a link only has its messages in the destination removed,
+// since the original stayed. The move is forgotten afterwards.
+func (a Automove) undo(ctx context.Context, ts string) error {
+	moveCtx, cancel := context.WithTimeout(ctx, moveTimeout)
+	defer cancel()
+	record, ok := records.ByCopy(a.From, ts)
+	var err error
+	switch {
+	case !ok:
+	case record.Kept:
+		err = deleteCopies(moveCtx, a.From, ts)
+	default:
+		_, err = a.Do(moveCtx, ts)
+	}
+	finishErr := records.Finish(a.From, ts, nil)
+	if finishErr != nil {
+		fmt.Fprintln(os.Stderr, "Cannot unlock the thread: "+finishErr.Error())
+	}
+	if !ok {
+		notify(ctx, a, "This move was undone already.")
+		return nil
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Fprintln(os.Stderr, describe(err))
+			notifyMoveFailure(ctx, a, err)
+		}
+		return err
+	}
+	if record.Tombstone != "" {
+		err = api.DeleteBotMessage(ctx, record.From, record.Tombstone)
+		if err != nil && !slack.IsError(err, "message_not_found") {
+			fmt.Fprintln(os.Stderr, "Cannot delete the tombstone: "+describe(err))
+		}
+	}
+	err = records.Undo(record)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot record the undo: "+err.Error())
+	}
+	audit("undone", "move of "+record.Ts+" from "+record.From+" to "+record.To+" by "+a.User.Id)
+	if record.Kept {
+		notify(ctx, a, "The move was undone, the copy of the thread was removed.")
+	} else {
+		notify(ctx, a, "The move was undone, the thread is back in <#"+record.From+">.")
+	}
+	return nil
+}
+
+// deleteCopies removes what the bot posted in a thread of the destination.
+// Replies people and other bots added there stay.
+func deleteCopies(ctx context.Context, channel string, ts string) error {
+	thread := slack.NewThreadIterator(api, channel, ts, 100)
+	err := thread.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("Cannot retrieve thread: %w", err)
+	}
+	var posted []string
+	for thread.Next(ctx) {
+		if m := thread.Message(); m.BotId != "" {
+			posted = append(posted, m.Ts)
+		}
+	}
+	if err := thread.Err(); err != nil {
+		return fmt.Errorf("Cannot retrieve thread: %w", err)
+	}
+	for _, ts := range posted {
+		err := api.DeleteBotMessage(ctx, channel, ts)
+		if err != nil && !slack.IsError(err, "message_not_found", "cant_delete_message") {
+			return fmt.Errorf("Cannot delete the copy: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aageorg/slackbot_prod/slack"
+)
+
+// handleMemberJoined greets a channel the bot was added to with the
+// automoves from or to it.
+func handleMemberJoined(ctx context.Context, callback slack.Callback) {
+	bot := callback.BotUserId()
+	if bot == "" {
+		bot = tokens.BotUserId()
+	}
+	if bot == "" || callback.Event.User != bot {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Event callback received: the bot joined "+callback.Event.Channel)
+	blocks, text := welcomeMessage(callback.Event.Channel)
+	_, err := api.PostMessage(ctx, slack.PostMessageRequest{Channel: callback.Event.Channel, Text: text, Blocks: blocks}, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot post the welcome message: "+describe(err))
+	}
+}
+
+// welcomeMessage lists the automoves of the channel, with the text shown in
+// notifications.
+func welcomeMessage(channel string) ([]slack.Block, string) {
+	mrkdwn := func(text string) *slack.Element {
+		return &slack.Element{Type: "mrkdwn", Text: text}
+	}
+	text := "Hi! I move threads between channels."
+	blocks := []slack.Block{{Type: "section", Text: mrkdwn(text)}}
+	verbs := map[string]string{modeMove: "moves", modeCopy: "copies", modeLink: "links"}
+	var rules []string
+	for _, move := range settings.Automoves {
+		if move.From != channel && move.To != channel {
+			continue
+		}
+		line := "• :" + move.Trigger + ": " + verbs[move.mode()] + " a thread from <#" + move.From + "> to <#" + move.To + ">"
+		if reason := move.paused(); reason != "" {
+			line += " (paused: " + reason + ")"
+		}
+		rules = append(rules, line)
+	}
+	if len(rules) == 0 {
+		blocks = append(blocks, slack.Block{Type: "section", Text: mrkdwn("No automoves use this channel yet.")})
+	} else {
+		blocks = append(blocks, sectionBlocks("*Automoves of this channel*\n"+strings.Join(rules, "\n"))...)
+	}
+	var who string
+	if len(settings.PermittedUsers) == 0 {
+		who = "Nobody is permitted to trigger them yet."
+	} else {
+		var users []string
+		for _, u := range settings.PermittedUsers {
+			users = append(users, "<@"+u+">")
+		}
+		who = "Reactions of " + strings.Join(users, ", ") + " count"
+		if settings.NecessaryVotes > 0 {
+			who += ", a thread moves after " + strconv.Itoa(settings.NecessaryVotes) + " of them."
+		} else {
+			who += ", the first one moves the thread."
+		}
+	}
+	blocks = append(blocks,
+		slack.Block{Type: "section", Text: mrkdwn(who)},
+		slack.Block{Type: "context", Elements: []slack.Element{{Type: "mrkdwn", Text: "They can also mention me in a thread with \"move to #channel\", \"copy to #channel\" or \"link to #channel\", or use the Move thread… shortcut. /showautomoves lists all automoves."}}},
+	)
+	return blocks, text
+}